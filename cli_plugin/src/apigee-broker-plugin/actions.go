@@ -0,0 +1,157 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"regexp"
+	"strings"
+)
+
+//Action is something the service broker does when an application is bound
+type Action string
+
+//Actions understood by the service broker, in the order the broker expects them
+const (
+	ActionProxy Action = "proxy"
+	ActionBind  Action = "bind"
+)
+
+var knownActions = []Action{ActionProxy, ActionBind}
+
+// the broker splits actions on whitespace and commas, so accept the same here
+var actionSeparator = regexp.MustCompile(`[\s,]+`)
+
+//ActionSet is the set of actions requested for a bind
+type ActionSet map[Action]bool
+
+//ParseActions parses a space or comma separated list of actions, rejecting any the broker does not know
+func ParseActions(value string) (ActionSet, error) {
+	actions := make(ActionSet)
+	for _, field := range actionSeparator.Split(value, -1) {
+		if field == "" {
+			continue
+		}
+		action := Action(strings.ToLower(field))
+		if !isKnownAction(action) {
+			if suggestion := suggestAction(action); suggestion != "" {
+				return nil, newCommandErrorf(ExitUsage, "Unknown action \"%s\", did you mean \"%s\"?", field, suggestion)
+			}
+			return nil, newCommandErrorf(ExitUsage, "Unknown action \"%s\", valid actions are \"proxy\" and \"bind\"", field)
+		}
+		actions[action] = true
+	}
+	if len(actions) == 0 {
+		return nil, newCommandErrorf(ExitMissingInput, "Did not set the required value for \"action\". Exiting")
+	}
+	return actions, nil
+}
+
+//String returns the actions in the canonical form sent to the service broker, such as "proxy bind"
+func (a ActionSet) String() string {
+	names := make([]string, 0, len(a))
+	for _, action := range knownActions {
+		if a[action] {
+			names = append(names, string(action))
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+func isKnownAction(action Action) bool {
+	for _, known := range knownActions {
+		if action == known {
+			return true
+		}
+	}
+	return false
+}
+
+//suggestAction returns the known action closest to a mistyped one, or "" when none is close
+func suggestAction(action Action) Action {
+	var suggestion Action
+	best := 3
+	for _, known := range knownActions {
+		distance := editDistance(string(action), string(known))
+		if distance < best {
+			best = distance
+			suggestion = known
+		}
+	}
+	return suggestion
+}
+
+//editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+//actionValue is a flag.Value that lets --action be repeated, collecting every value into one comma separated string
+type actionValue struct {
+	value *string
+}
+
+func (a actionValue) String() string {
+	if a.value == nil {
+		return ""
+	}
+	return *a.value
+}
+
+func (a actionValue) Set(value string) error {
+	if _, err := ParseActions(value); err != nil {
+		return err
+	}
+	if *a.value != "" {
+		*a.value += ","
+	}
+	*a.value += value
+	return nil
+}
+
+//actionFlag defines a repeatable --action flag and returns the string its values are collected into
+func actionFlag(flags *flag.FlagSet, usage string) *string {
+	value := new(string)
+	flags.Var(actionValue{value}, "action", usage)
+	return value
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestParseActions(t *testing.T) {
+	tests := []struct {
+		value    string
+		want     string
+		wantCode int
+		wantErr  string
+	}{
+		{value: "bind", want: "bind"},
+		{value: "proxy", want: "proxy"},
+		{value: "proxy bind", want: "proxy bind"},
+		{value: "bind proxy", want: "proxy bind"},
+		{value: "Bind,PROXY", want: "proxy bind"},
+		{value: " proxy ,, bind bind ", want: "proxy bind"},
+		{value: "bnd", wantCode: ExitUsage, wantErr: `did you mean "bind"?`},
+		{value: "proxy prxy", wantCode: ExitUsage, wantErr: `did you mean "proxy"?`},
+		{value: "deploy", wantCode: ExitUsage, wantErr: `valid actions are "proxy" and "bind"`},
+		{value: " , ", wantCode: ExitMissingInput, wantErr: `"action"`},
+	}
+
+	for _, test := range tests {
+		actions, err := ParseActions(test.value)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) || ExitCode(err) != test.wantCode {
+				t.Errorf("ParseActions(%q) error = %v (code %d), want %q (code %d)", test.value, err, ExitCode(err), test.wantErr, test.wantCode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseActions(%q) unexpected error: %v", test.value, err)
+			continue
+		}
+		if got := actions.String(); got != test.want {
+			t.Errorf("ParseActions(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestActionFlagIsRepeatable(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	action := actionFlag(flags, "")
+	if err := flags.Parse([]string{"--action", "bind", "--action", "proxy"}); err != nil {
+		t.Fatal(err)
+	}
+	actions, err := ParseActions(*action)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actions.String() != "proxy bind" {
+		t.Errorf("got %q, want %q", actions.String(), "proxy bind")
+	}
+}
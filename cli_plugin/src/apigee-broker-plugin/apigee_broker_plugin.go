@@ -18,21 +18,47 @@ package main
 
 import (
 	"bufio"
-	"errors"
+	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"code.cloudfoundry.org/cli/plugin"
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
 // ApigeeBrokerPlugin is the struct implementing the interface defined by the core CLI
-type ApigeeBrokerPlugin struct{}
+type ApigeeBrokerPlugin struct {
+	// In is read for answers to prompts
+	In io.Reader
+	// Out receives prompts, messages and errors
+	Out io.Writer
+	// ReadPassword reads a hidden value from the terminal without echoing it
+	ReadPassword func() ([]byte, error)
+	// IsTerminal reports whether In is attached to a terminal
+	IsTerminal func() bool
+	// Exit ends the command with the given status code
+	Exit func(code int)
+	// CfHome is the directory holding the cf cli's .cf folder, where the plugin keeps its configuration
+	CfHome string
+	// Getenv looks up environment variables, defaulting to os.Getenv
+	Getenv func(key string) string
+
+	nonInteractive bool
+	dryRun         bool
+	reader         *bufio.Reader
+	redactor       Redactor
+	temps          []string
+	tempMutex      sync.Mutex
+	ctx            context.Context
+}
 
 // UserInput is used to keep track of flag values and properties
 type UserInput struct {
@@ -44,7 +70,24 @@ type UserInput struct {
 /* Cloud Foundry Required Methods */
 
 func main() {
-	plugin.Start(new(ApigeeBrokerPlugin))
+	plugin.Start(NewApigeeBrokerPlugin())
+}
+
+//NewApigeeBrokerPlugin returns a plugin that reads from and writes to the process's standard streams
+func NewApigeeBrokerPlugin() *ApigeeBrokerPlugin {
+	return &ApigeeBrokerPlugin{
+		In:  os.Stdin,
+		Out: os.Stdout,
+		ReadPassword: func() ([]byte, error) {
+			return terminal.ReadPassword(int(syscall.Stdin))
+		},
+		IsTerminal: func() bool {
+			return terminal.IsTerminal(int(syscall.Stdin))
+		},
+		Exit:   os.Exit,
+		CfHome: CfHomeDir(),
+		Getenv: os.Getenv,
+	}
 }
 
 //GetMetadata is required by the CLI struct and returns command information such that the
@@ -58,105 +101,183 @@ func (c *ApigeeBrokerPlugin) GetMetadata() plugin.PluginMetadata {
 			Build: 1,
 		},
 		Commands: []plugin.Command{
+			{
+				Name:     "apigee-bind",
+				Alias:    "ab",
+				HelpText: "Binds an application to an Apigee service instance, using the flags of the instance's plan",
+				UsageDetails: plugin.Usage{
+					Usage: "cf apigee-bind --service SERVICE_INSTANCE --app APP_NAME --apigee_org APIGEE_ORGANIZATION\n   --apigee_env APIGEE_ENVIRONMENT --action ACTION\n   (--user APIGEE_USERNAME --pass APIGEE_PASSWORD | --bearer APIGEE_BEARER_TOKEN | --basic APIGEE_BASIC_CREDENTIALS)\n   [--bearer-file FILE | --bearer-stdin] [--pass-file FILE] [--mgmt_host MANAGEMENT_HOST] [--profile PROFILE] [--non-interactive]\n\n   org plan: [--route ROUTE | --domain APP_DOMAIN] [--protocol TARGET_APP_PROTOCOL] [--host API_HOST]\n   microgateway plan: --micro MICROGATEWAY_APP_ROUTE [--route ROUTE | --domain APP_DOMAIN] [--protocol TARGET_APP_PROTOCOL]\n   microgateway-coresident plan: --edgemicro_key EDGEMICRO_KEY --edgemicro_secret EDGEMICRO_SECRET\n      --target_app_route TARGET_APP_ROUTE --target_app_port TARGET_APP_PORT [--start | --no-start]\n\n   Run \"cf apigee-bind --service SERVICE_INSTANCE --help\" to list the flags of an instance's plan",
+					Options: map[string]string{
+						"-service":         "Service instance name to bind to. Defaults to the only Apigee service instance in the space",
+						"-app":             "Name of application to bind to [required]",
+						"-apigee_org":      "Apigee organization [required]",
+						"-apigee_env":      "Apigee environment [required]",
+						"-action":          "Action to take: \"proxy\", \"bind\" or both, given as \"proxy bind\", \"proxy,bind\" or by repeating --action [required]",
+						"-user":            "Apigee user name",
+						"-pass":            "Apigee password",
+						"-bearer":          "Apigee bearer token. Defaults to the token cached by cf apigee-login",
+						"-basic":           "Apigee basic authentication credentials, base64 encoded \"user:password\"",
+						"-profile":         "Name of the profile to fill unset flags from, instead of the active profile",
+						"-non-interactive": "Fail instead of prompting for missing values (default when stdin is not a terminal)",
+						"-dry-run":         "Print the cf commands that would be run, with secrets hidden, instead of running them",
+					},
+				},
+			},
+			{
+				Name:     "apigee-unbind",
+				Alias:    "au",
+				HelpText: "Unbinds an application from an Apigee service instance of any plan",
+				UsageDetails: plugin.Usage{
+					Usage: "cf apigee-unbind --service SERVICE_INSTANCE --app APP_NAME [--route ROUTE | --domain APP_DOMAIN] [--profile PROFILE] [--non-interactive]",
+					Options: map[string]string{
+						"-service":         "Service instance name to unbind from. Defaults to the only Apigee service instance in the space",
+						"-app":             "Name of application to unbind from [required]",
+						"-route":           "Route of the application to unbind, as host.domain/path. Only for the org and microgateway plans, when the application has several routes",
+						"-domain":          "Only consider the application's routes on this domain. Only for the org and microgateway plans [optional]",
+						"-profile":         "Name of the profile to fill unset flags from, instead of the active profile",
+						"-non-interactive": "Fail instead of prompting for missing values (default when stdin is not a terminal)",
+						"-dry-run":         "Print the cf commands that would be run, with secrets hidden, instead of running them",
+					},
+				},
+			},
 			{
 				Name:     "apigee-bind-mgc",
 				Alias:    "abc",
-				HelpText: "Binds and starts up an application with the microgateway-coresident plan",
+				HelpText: "Deprecated, use apigee-bind. Binds and starts up an application with the microgateway-coresident plan",
 				UsageDetails: plugin.Usage{
-					Usage: "cf apigee-bind-mgc --app APP_NAME --service SERVICE_INSTANCE --apigee_org APIGEE_ORGANIZATION\n   --apigee_env APIGEE_ENVIRONMENT --edgemicro_key EDGEMICRO_KEY --edgemicro_secret EDGEMICRO_SECRET\n   --target_app_route TARGET_APP_ROUTE --target_app_port TARGET_APP_PORT --action ACTION\n   (--user APIGEE_USERNAME --pass APIGEE_PASSWORD | --bearer APIGEE_BEARER_TOKEN)",
+					Usage: "cf apigee-bind-mgc --app APP_NAME --service SERVICE_INSTANCE --apigee_org APIGEE_ORGANIZATION\n   --apigee_env APIGEE_ENVIRONMENT --edgemicro_key EDGEMICRO_KEY --edgemicro_secret EDGEMICRO_SECRET\n   --target_app_route TARGET_APP_ROUTE --target_app_port TARGET_APP_PORT --action ACTION\n   (--user APIGEE_USERNAME --pass APIGEE_PASSWORD | --bearer APIGEE_BEARER_TOKEN | --basic APIGEE_BASIC_CREDENTIALS)\n   [--bearer-file FILE | --bearer-stdin] [--pass-file FILE] [--mgmt_host MANAGEMENT_HOST]\n   [--start | --no-start] [--profile PROFILE] [--non-interactive]",
 					Options: map[string]string{
 						"-app":              "Name of application to bind to [required]",
-						"-service":          "Service instance name to bind to [required]",
+						"-service":          "Service instance name to bind to. Defaults to the only Apigee service instance of the plan in the space",
 						"-apigee_org":       "Apigee organization [required]",
 						"-apigee_env":       "Apigee environment [required]",
 						"-edgemicro_key":    "Microgateway key [required]",
 						"-edgemicro_secret": "Microgateway secret [required]",
 						"-target_app_route": "Target application route [required]",
 						"-target_app_port":  "Target application port [required]",
-						"-action":           "Action to take (\"bind\", \"proxy bind\", or \"proxy\") [required]",
+						"-action":           "Action to take: \"proxy\", \"bind\" or both, given as \"proxy bind\", \"proxy,bind\" or by repeating --action [required]",
 						"-user":             "Apigee user name",
 						"-pass":             "Apigee password",
-						"-bearer":           "Apigee bearer token",
+						"-bearer":           "Apigee bearer token. Defaults to the token cached by cf apigee-login",
+						"-basic":            "Apigee basic authentication credentials, base64 encoded \"user:password\"",
+						"-bearer-file":      "File containing the Apigee bearer token",
+						"-pass-file":        "File containing the Apigee password",
+						"-bearer-stdin":     "Read the Apigee bearer token from stdin",
+						"-mgmt_host":        "Apigee management API host to look up credentials for in ~/.netrc [optional, defaults to api.enterprise.apigee.com]",
+						"-start":            "Start the application after binding without prompting",
+						"-no-start":         "Do not start the application after binding and do not prompt",
+						"-profile":          "Name of the profile to fill unset flags from, instead of the active profile",
+						"-non-interactive":  "Fail instead of prompting for missing values (default when stdin is not a terminal)",
+						"-dry-run":          "Print the cf commands that would be run, with secrets hidden, instead of running them",
 					},
 				},
 			},
 			{
 				Name:     "apigee-bind-mg",
 				Alias:    "abm",
-				HelpText: "Binds an application with the microgateway plan",
+				HelpText: "Deprecated, use apigee-bind. Binds an application with the microgateway plan",
 				UsageDetails: plugin.Usage{
-					Usage: "cf apigee-bind-mg --app APP_NAME --service SERVICE_INSTANCE\n   --apigee_org APIGEE_ORGANIZATION --apigee_env APIGEE_ENVIRONMENT \n   --micro MICROGATEWAY_APP_ROUTE --domain APP_DOMAIN --action ACTION [--protocol TARGET_APP_PROTOCOL]\n   (--user APIGEE_USERNAME --pass APIGEE_PASSWORD | --bearer APIGEE_BEARER_TOKEN)",
+					Usage: "cf apigee-bind-mg --app APP_NAME --service SERVICE_INSTANCE\n   --apigee_org APIGEE_ORGANIZATION --apigee_env APIGEE_ENVIRONMENT \n   --micro MICROGATEWAY_APP_ROUTE --action ACTION [--route ROUTE | --domain APP_DOMAIN] [--protocol TARGET_APP_PROTOCOL]\n   (--user APIGEE_USERNAME --pass APIGEE_PASSWORD | --bearer APIGEE_BEARER_TOKEN | --basic APIGEE_BASIC_CREDENTIALS)\n   [--bearer-file FILE | --bearer-stdin] [--pass-file FILE] [--mgmt_host MANAGEMENT_HOST] [--profile PROFILE] [--non-interactive]",
 					Options: map[string]string{
-						"-app":        "Hostname of application to bind to [required]",
-						"-service":    "Service instance name to bind to [required]",
-						"-apigee_org": "Apigee organization [required]",
-						"-apigee_env": "Apigee environment [required]",
-						"-action":     "Action to take (\"bind\", \"proxy bind\", or \"proxy\") [required]",
-						"-protocol":   "Target application protocol [optional]",
-						"-micro":      "Route of application acting as microgateway [required]",
-						"-user":       "Apigee user name",
-						"-pass":       "Apigee password",
-						"-bearer":     "Apigee bearer token",
-						"-domain":     "Domain of application to bind to [required]",
+						"-app":             "Name of application to bind to [required]",
+						"-service":         "Service instance name to bind to. Defaults to the only Apigee service instance of the plan in the space",
+						"-apigee_org":      "Apigee organization [required]",
+						"-apigee_env":      "Apigee environment [required]",
+						"-action":          "Action to take: \"proxy\", \"bind\" or both, given as \"proxy bind\", \"proxy,bind\" or by repeating --action [required]",
+						"-protocol":        "Target application protocol [optional]",
+						"-micro":           "Route of application acting as microgateway [required]",
+						"-user":            "Apigee user name",
+						"-pass":            "Apigee password",
+						"-bearer":          "Apigee bearer token. Defaults to the token cached by cf apigee-login",
+						"-basic":           "Apigee basic authentication credentials, base64 encoded \"user:password\"",
+						"-bearer-file":     "File containing the Apigee bearer token",
+						"-pass-file":       "File containing the Apigee password",
+						"-bearer-stdin":    "Read the Apigee bearer token from stdin",
+						"-mgmt_host":       "Apigee management API host to look up credentials for in ~/.netrc [optional, defaults to api.enterprise.apigee.com]",
+						"-profile":         "Name of the profile to fill unset flags from, instead of the active profile",
+						"-non-interactive": "Fail instead of prompting for missing values (default when stdin is not a terminal)",
+						"-dry-run":         "Print the cf commands that would be run, with secrets hidden, instead of running them",
+						"-route":           "Route of the application to bind, as host.domain/path. Only needed when the application has several routes",
+						"-domain":          "Only consider the application's routes on this domain [optional]",
 					},
 				},
 			},
 			{
 				Name:     "apigee-bind-org",
 				Alias:    "abo",
-				HelpText: "Binds an application with the org plan",
+				HelpText: "Deprecated, use apigee-bind. Binds an application with the org plan",
 				UsageDetails: plugin.Usage{
-					Usage: "cf apigee-bind-org --app APP_NAME --service SERVICE_INSTANCE\n   --apigee_org APIGEE_ORGANIZATION --apigee_env APIGEE_ENVIRONMENT\n   --domain APP_DOMAIN --action ACTION [--protocol TARGET_APP_PROTOCOL]\n   (--user APIGEE_USERNAME --pass APIGEE_PASSWORD | --bearer APIGEE_BEARER_TOKEN)",
+					Usage: "cf apigee-bind-org --app APP_NAME --service SERVICE_INSTANCE\n   --apigee_org APIGEE_ORGANIZATION --apigee_env APIGEE_ENVIRONMENT\n   --action ACTION [--route ROUTE | --domain APP_DOMAIN] [--protocol TARGET_APP_PROTOCOL]\n   (--user APIGEE_USERNAME --pass APIGEE_PASSWORD | --bearer APIGEE_BEARER_TOKEN | --basic APIGEE_BASIC_CREDENTIALS)\n   [--bearer-file FILE | --bearer-stdin] [--pass-file FILE] [--mgmt_host MANAGEMENT_HOST] [--profile PROFILE] [--non-interactive]",
 					Options: map[string]string{
-						"-app":        "Hostname of application to bind to [required]",
-						"-service":    "Service instance name to bind to [required]",
-						"-apigee_org": "Apigee organization [required]",
-						"-apigee_env": "Apigee environment [required]",
-						"-action":     "Action to take (\"bind\", \"proxy bind\", or \"proxy\") [required]",
-						"-protocol":   "Target application protocol [optional]",
-						"-user":       "Apigee user name",
-						"-pass":       "Apigee password",
-						"-bearer":     "Apigee bearer token",
-						"-domain":     "Domain of application to bind to [required]",
-						"-host":       "The host domain to which API calls are made. Specify a value only if your Apigee proxy domain is not the same as that given by your virtual host [optional]",
+						"-app":             "Name of application to bind to [required]",
+						"-service":         "Service instance name to bind to. Defaults to the only Apigee service instance of the plan in the space",
+						"-apigee_org":      "Apigee organization [required]",
+						"-apigee_env":      "Apigee environment [required]",
+						"-action":          "Action to take: \"proxy\", \"bind\" or both, given as \"proxy bind\", \"proxy,bind\" or by repeating --action [required]",
+						"-protocol":        "Target application protocol [optional]",
+						"-user":            "Apigee user name",
+						"-pass":            "Apigee password",
+						"-bearer":          "Apigee bearer token. Defaults to the token cached by cf apigee-login",
+						"-basic":           "Apigee basic authentication credentials, base64 encoded \"user:password\"",
+						"-bearer-file":     "File containing the Apigee bearer token",
+						"-pass-file":       "File containing the Apigee password",
+						"-bearer-stdin":    "Read the Apigee bearer token from stdin",
+						"-mgmt_host":       "Apigee management API host to look up credentials for in ~/.netrc [optional, defaults to api.enterprise.apigee.com]",
+						"-profile":         "Name of the profile to fill unset flags from, instead of the active profile",
+						"-non-interactive": "Fail instead of prompting for missing values (default when stdin is not a terminal)",
+						"-dry-run":         "Print the cf commands that would be run, with secrets hidden, instead of running them",
+						"-route":           "Route of the application to bind, as host.domain/path. Only needed when the application has several routes",
+						"-domain":          "Only consider the application's routes on this domain [optional]",
+						"-host":            "The host domain to which API calls are made. Specify a value only if your Apigee proxy domain is not the same as that given by your virtual host [optional]",
 					},
 				},
 			},
 			{
 				Name:     "apigee-unbind-mgc",
 				Alias:    "auc",
-				HelpText: "Unbinds an application from the microgateway-coresident plan",
+				HelpText: "Deprecated, use apigee-unbind. Unbinds an application from the microgateway-coresident plan",
 				UsageDetails: plugin.Usage{
-					Usage: "cf apigee-unbind-mgc --app APP_NAME --service SERVICE_INSTANCE",
+					Usage: "cf apigee-unbind-mgc --app APP_NAME --service SERVICE_INSTANCE [--profile PROFILE] [--non-interactive]",
 					Options: map[string]string{
-						"-app":     "Hostname of application to unbind from [required]",
-						"-service": "Service instance name to bind to [required]",
+						"-app":             "Name of application to unbind from [required]",
+						"-service":         "Service instance name to unbind from. Defaults to the only Apigee service instance of the plan in the space",
+						"-profile":         "Name of the profile to fill unset flags from, instead of the active profile",
+						"-non-interactive": "Fail instead of prompting for missing values (default when stdin is not a terminal)",
+						"-dry-run":         "Print the cf commands that would be run, with secrets hidden, instead of running them",
 					},
 				},
 			},
 			{
 				Name:     "apigee-unbind-org",
 				Alias:    "auo",
-				HelpText: "Unbinds an application from the org plan",
+				HelpText: "Deprecated, use apigee-unbind. Unbinds an application from the org plan",
 				UsageDetails: plugin.Usage{
-					Usage: "cf apigee-unbind-org --app APP_NAME --domain DOMAIN --service SERVICE_INSTANCE",
+					Usage: "cf apigee-unbind-org --app APP_NAME [--route ROUTE | --domain APP_DOMAIN] --service SERVICE_INSTANCE [--profile PROFILE] [--non-interactive]",
 					Options: map[string]string{
-						"-app":     "Hostname of application to unbind from [required]",
-						"-service": "Service instance name to bind to [required]",
-						"-domain":  "Domain of application to unbind from [required]",
+						"-app":             "Name of application to unbind from [required]",
+						"-service":         "Service instance name to unbind from. Defaults to the only Apigee service instance of the plan in the space",
+						"-route":           "Route of the application to unbind, as host.domain/path. Only needed when the application has several routes",
+						"-domain":          "Only consider the application's routes on this domain [optional]",
+						"-profile":         "Name of the profile to fill unset flags from, instead of the active profile",
+						"-non-interactive": "Fail instead of prompting for missing values (default when stdin is not a terminal)",
+						"-dry-run":         "Print the cf commands that would be run, with secrets hidden, instead of running them",
 					},
 				},
 			},
 			{
 				Name:     "apigee-unbind-mg",
 				Alias:    "aum",
-				HelpText: "Unbinds an application from the microgateway plan",
+				HelpText: "Deprecated, use apigee-unbind. Unbinds an application from the microgateway plan",
 				UsageDetails: plugin.Usage{
-					Usage: "cf apigee-unbind-mg --app APP_NAME --domain DOMAIN --service SERVICE_INSTANCE",
+					Usage: "cf apigee-unbind-mg --app APP_NAME [--route ROUTE | --domain APP_DOMAIN] --service SERVICE_INSTANCE [--profile PROFILE] [--non-interactive]",
 					Options: map[string]string{
-						"-app":     "Name of application to unbind from [required]",
-						"-service": "Service instance name to bind to [required]",
-						"-domain":  "Domain of application to unbind from [required]",
+						"-app":             "Name of application to unbind from [required]",
+						"-service":         "Service instance name to unbind from. Defaults to the only Apigee service instance of the plan in the space",
+						"-route":           "Route of the application to unbind, as host.domain/path. Only needed when the application has several routes",
+						"-domain":          "Only consider the application's routes on this domain [optional]",
+						"-profile":         "Name of the profile to fill unset flags from, instead of the active profile",
+						"-non-interactive": "Fail instead of prompting for missing values (default when stdin is not a terminal)",
+						"-dry-run":         "Print the cf commands that would be run, with secrets hidden, instead of running them",
 					},
 				},
 			},
@@ -165,12 +286,78 @@ func (c *ApigeeBrokerPlugin) GetMetadata() plugin.PluginMetadata {
 				Alias:    "ap",
 				HelpText: "To push an application meant to be used with the microgateway-coresident plan. This will be pushed with as \"--no-start\" application. To obtain more information use --help",
 				UsageDetails: plugin.Usage{
-					Usage: "cf apigee-push [--app APP_NAME] [--archive ARCHIVE] [--config CONFIG_DIR] [--plugins PLUGINS-DIR]",
+					Usage: "cf apigee-push [--app APP_NAME] [--archive ARCHIVE | --path APP_DIR] [--config CONFIG_DIR] [--plugins PLUGINS-DIR]\n   [--coresident] [--non-interactive]\n   [--max-extracted-size MB] [--max-entries COUNT] [--max-ratio RATIO] [--archive-prefix DIR]\n   [--symlinks preserve|follow|reject] [--reproducible] [--exclude PATTERN]... [--verify-only]",
+					Options: map[string]string{
+						"-config":             "Path to configuration directory that contains a microgateway yaml [required]",
+						"-plugins":            "Path to configuration directory that contains custom plugins [optional]",
+						"-archive":            "For a Java application, this is the path to a Java application's archive",
+						"-path":               "For an application pushed from a directory, this is the path to the directory. A copy leaving out what .cfignore lists is pushed",
+						"-app":                "Name of application that will be pushed [optional]",
+						"-coresident":         "The application will be used with the microgateway-coresident plan, skips the prompt. Implied by --config and --plugins",
+						"-max-extracted-size": "Refuse archives extracting to more megabytes than this, 0 for no limit [optional, defaults to 1024]",
+						"-max-entries":        "Refuse archives with more entries than this, 0 for no limit [optional, defaults to 100000]",
+						"-max-ratio":          "Refuse archives with a file larger than 1 MB compressed more than this many times, 0 for no limit [optional, defaults to 200]",
+						"-archive-prefix":     "Directory in the archive to add the config and plugins directories to [optional, defaults to WEB-INF/ for WARs and the root otherwise]",
+						"-symlinks":           "Keep symbolic links in the application directory and the directories added, copy what they point to, or refuse them. Links leading outside of their directory are always refused [optional, defaults to preserve]",
+						"-exclude":            "Leave files of the config and plugins directories matching this .cfignore style pattern out, besides those their own .cfignore files list. Can be repeated [optional]",
+						"-reproducible":       "Write the same archive byte for byte from the same inputs and print its SHA-256. Entry times come from SOURCE_DATE_EPOCH when set [optional, defaults to true when CI is set]",
+						"-verify-only":        "Check the apigee_ARCHIVE written by an earlier apigee-push against --archive and the config and plugins directories, then exit without pushing [optional]",
+						"-non-interactive":    "Fail instead of prompting for missing values (default when stdin is not a terminal)",
+						"-dry-run":            "Print the cf commands that would be run, with secrets hidden, instead of running them",
+					},
+				},
+			},
+			{
+				Name:     "apigee-profile",
+				Alias:    "apf",
+				HelpText: "Manages named profiles holding default values for the bind and unbind commands",
+				UsageDetails: plugin.Usage{
+					Usage: "cf apigee-profile create NAME [--apigee_org APIGEE_ORGANIZATION] [--apigee_env APIGEE_ENVIRONMENT]\n   [--service SERVICE_INSTANCE] [--domain APP_DOMAIN] [--protocol TARGET_APP_PROTOCOL] [--use] [--force]\n   cf apigee-profile list\n   cf apigee-profile show [NAME]\n   cf apigee-profile use NAME\n   cf apigee-profile delete NAME",
 					Options: map[string]string{
-						"-config":  "Path to configuration directory that contains a microgateway yaml [required]",
-						"-plugins": "Path to configuration directory that contains custom plugins [optional]",
-						"-archive": "For a Java application, this is the path to a Java application's archive",
-						"-app":     "Name of application that will be pushed [optional]",
+						"-apigee_org": "Default Apigee organization",
+						"-apigee_env": "Default Apigee environment",
+						"-service":    "Default service instance name",
+						"-domain":     "Default domain of applications",
+						"-protocol":   "Default target application protocol",
+						"-use":        "Make the new profile the active one. The first profile created is always made active",
+						"-force":      "Replace the profile if one of the same name already exists",
+					},
+				},
+			},
+			{
+				Name:     "apigee-login",
+				Alias:    "al",
+				HelpText: "Logs in to Apigee and caches an access token that the bind commands use when no credentials are given",
+				UsageDetails: plugin.Usage{
+					Usage: "cf apigee-login [--user APIGEE_USERNAME] [--pass APIGEE_PASSWORD] [--mfa MFA_CODE] [--token_url TOKEN_URL]\n   [--non-interactive]",
+					Options: map[string]string{
+						"-user":            "Apigee user name",
+						"-pass":            "Apigee password",
+						"-mfa":             "Multi-factor authentication code, if enabled for the account [optional]",
+						"-token_url":       "Apigee OAuth token endpoint [optional, defaults to https://login.apigee.com/oauth/token]",
+						"-non-interactive": "Fail instead of prompting for missing values (default when stdin is not a terminal)",
+					},
+				},
+			},
+			{
+				Name:     "apigee-status",
+				Alias:    "as",
+				HelpText: "Shows the applications and routes bound to Apigee service instances in the targeted space",
+				UsageDetails: plugin.Usage{
+					Usage: "cf apigee-status",
+				},
+			},
+			{
+				Name:     "apigee-migrate",
+				Alias:    "amg",
+				HelpText: "Prints the standard cf commands that replace apigee-push and apigee-bind-mgc for the microgateway-coresident plan",
+				UsageDetails: plugin.Usage{
+					Usage: "cf apigee-migrate [apigee-bind-mgc and apigee-push flags] [--buildpack BUILDPACK] [--params-file FILE] [--script FILE]",
+					Options: map[string]string{
+						"-buildpack":   "Buildpack of the application, run after the microgateway_decorator buildpack [optional, defaults to nodejs_buildpack]",
+						"-params-file": "File to write the bind parameters to [optional, defaults to APP_NAME-apigee-bind-params.json]",
+						"-script":      "Write the commands to this shell script instead of printing them [optional]",
+						"-dry-run":     "Print the bind parameters and commands, with secrets hidden, instead of writing any files",
 					},
 				},
 			},
@@ -181,27 +368,75 @@ func (c *ApigeeBrokerPlugin) GetMetadata() plugin.PluginMetadata {
 // Run is the entry point when the core CLI is invoking a command defined
 // by a plugin.
 func (c *ApigeeBrokerPlugin) Run(cliConnection plugin.CliConnection, args []string) {
+	var err error
+	// Everything the plugin prints goes through the redactor, which learns the secrets as the command reads them
+	out := c.Out
+	c.Out = c.redactor.Writer(out)
+	defer func() {
+		c.flushOut()
+		c.Out = out
+	}()
+	c.redactor.AddArgs(args)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.ctx = ctx
+	stopHandling := c.handleInterrupts(cancel)
+
+	args, c.dryRun = stripDryRun(args)
+	if replacement, ok := deprecatedCommands[args[0]]; ok {
+		fmt.Fprintf(c.Out, "Note: \"cf %s\" is deprecated and will be removed in a future release, use \"cf %s\" instead\n", args[0], replacement)
+	}
 	switch args[0] {
+	case "apigee-bind":
+		err = c.ApigeeBindCommand(cliConnection, args)
+	case "apigee-unbind":
+		err = c.ApigeeUnbindCommand(cliConnection, args)
 	case "apigee-bind-mgc":
-		c.ApigeeBindServiceCommand(cliConnection, args)
+		err = c.ApigeeBindServiceCommand(cliConnection, args, nil)
 	case "apigee-bind-mg":
-		c.ApigeeBindRouteCommand(cliConnection, args, true)
+		err = c.ApigeeBindRouteCommand(cliConnection, args, PlanMicro, nil)
 	case "apigee-bind-org":
-		c.ApigeeBindRouteCommand(cliConnection, args, false)
+		err = c.ApigeeBindRouteCommand(cliConnection, args, PlanOrg, nil)
 	case "apigee-push":
-		c.ApigeePushCommand(cliConnection, args)
+		err = c.ApigeePushCommand(cliConnection, args)
 	case "apigee-unbind-org":
-		c.ApigeeUnbindCommand(cliConnection, args, true)
+		err = c.ApigeeUnbindPlanCommand(cliConnection, args, PlanOrg, nil)
 	case "apigee-unbind-mg":
-		c.ApigeeUnbindCommand(cliConnection, args, true)
+		err = c.ApigeeUnbindPlanCommand(cliConnection, args, PlanMicro, nil)
 	case "apigee-unbind-mgc":
-		c.ApigeeUnbindCommand(cliConnection, args, false)
+		err = c.ApigeeUnbindPlanCommand(cliConnection, args, PlanCoresident, nil)
+	case "apigee-profile":
+		err = c.ApigeeProfileCommand(cliConnection, args)
+	case "apigee-login":
+		err = c.ApigeeLoginCommand(cliConnection, args)
+	case "apigee-status":
+		err = c.ApigeeStatusCommand(cliConnection, args)
+	case "apigee-migrate":
+		err = c.ApigeeMigrateCommand(cliConnection, args)
+	}
+	stopHandling()
+
+	if ctx.Err() != nil {
+		// Whatever the command returned, it stopped because of the interrupt
+		c.removeTemps()
+		c.flushOut()
+		c.Exit(ExitInterrupted)
+	} else if err != nil {
+		// Exit does not return, so clean up first
+		c.removeTemps()
+		fmt.Fprintln(c.Out, err)
+		c.flushOut()
+		c.Exit(ExitCode(err))
 	}
 }
 
-//ApigeeBindRouteCommand is responsible for binding an app to either the org or microgateway plans
-func (c *ApigeeBrokerPlugin) ApigeeBindRouteCommand(cliConnection plugin.CliConnection, args []string, isMicroPlan bool) {
-	flags := flag.NewFlagSet("apigee-route-bind", flag.ExitOnError)
+//ApigeeBindRouteCommand is responsible for binding an app to either the org or microgateway plans. instance, when not
+//nil, is the service instance ResolvePlan looked up
+func (c *ApigeeBrokerPlugin) ApigeeBindRouteCommand(cliConnection plugin.CliConnection, args []string, plan Plan, instance *plugin_models.GetService_Model) error {
+	isMicroPlan := plan == PlanMicro
+	flags := flag.NewFlagSet("apigee-route-bind", flag.ContinueOnError)
+	flags.SetOutput(c.Out)
 	generalConfig := map[string]UserInput{
 		"service": UserInput{
 			value:         flags.String("service", "", "Service instance name to bind to [required]: "),
@@ -224,21 +459,25 @@ func (c *ApigeeBrokerPlugin) ApigeeBindRouteCommand(cliConnection plugin.CliConn
 			hiddenInput:   false,
 		},
 		"action": UserInput{
-			value:         flags.String("action", "", "Action to take (\"bind\", \"proxy bind\", or \"proxy\") [required]: "),
-			requiredInput: true,
-			hiddenInput:   false,
-		},
-		"domain": UserInput{
-			value:         flags.String("domain", "", "Domain of application to bind to [required]: "),
+			value:         actionFlag(flags, "Action to take (\"bind\", \"proxy bind\", or \"proxy\") [required]: "),
 			requiredInput: true,
 			hiddenInput:   false,
 		},
 		"app": UserInput{
-			value:         flags.String("app", "", "Application to bind to [required]: "),
+			value:         flags.String("app", "", "Name of application to bind to [required]: "),
 			requiredInput: true,
 			hiddenInput:   false,
 		},
 	}
+	// The route is looked up from the application rather than prompted for
+	routeConfig := map[string]UserInput{
+		"route": UserInput{
+			value: flags.String("route", "", "Route of the application to bind, as host.domain/path"),
+		},
+		"domain": UserInput{
+			value: flags.String("domain", "", "Domain of the application route to bind"),
+		},
+	}
 
 	if isMicroPlan {
 		generalConfig["micro"] = UserInput{
@@ -259,6 +498,11 @@ func (c *ApigeeBrokerPlugin) ApigeeBindRouteCommand(cliConnection plugin.CliConn
 			requiredInput: false,
 			hiddenInput:   true,
 		},
+		"basic": UserInput{
+			value:         flags.String("basic", "", "Apigee basic authentication credentials: "),
+			requiredInput: false,
+			hiddenInput:   true,
+		},
 		"pass": UserInput{
 			value:         flags.String("pass", "", "Apigee password: "),
 			requiredInput: true,
@@ -271,71 +515,104 @@ func (c *ApigeeBrokerPlugin) ApigeeBindRouteCommand(cliConnection plugin.CliConn
 		},
 	}
 
+	nonInteractive := flags.Bool("non-interactive", false, "Fail instead of prompting for missing values")
+	profile := flags.String("profile", "", "Name of the profile to fill unset flags from")
+	credentialSources := AddCredentialFlags(flags)
+
 	// Parse from [1] since [0] is command name
 	err := flags.Parse(args[1:])
+	if err == flag.ErrHelp {
+		return nil
+	}
 	if err != nil {
-		fmt.Println("Error: Couldn't parse arguments: ", err)
-		os.Exit(1)
+		return newCommandErrorf(ExitUsage, "Error: Couldn't parse arguments: %s", err.Error())
 	}
 
 	// Check to make sure there are no extra arguments
 	if flags.NArg() > 0 {
-		fmt.Println("Error: Unknown extra arguments")
-		os.Exit(1)
+		return newCommandErrorf(ExitUsage, "Error: Unknown extra arguments")
+	}
+	c.SetNonInteractive(*nonInteractive)
+
+	err = c.ApplyProfile(*profile, mergeConfigs(generalConfig, routeConfig), flags)
+	if err != nil {
+		return err
+	}
+
+	err = c.SuggestService(cliConnection, generalConfig["service"].value, plan)
+	if err != nil {
+		return err
 	}
 
 	//Get consistent argument ordering for user prompt (based on lexigraphical order)
 	generalKeyOrdering := make([]string, 0)
 	visitor := func(f *flag.Flag) {
-		if _, ok := authConfig[f.Name]; !ok {
+		if _, ok := generalConfig[f.Name]; ok {
 			generalKeyOrdering = append(generalKeyOrdering, f.Name)
 		}
 	}
 	flags.VisitAll(visitor)
 
-	err = c.ValidateAuth(authConfig, flags)
+	err = c.ResolveCredentials(authConfig, credentialSources)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return err
 	}
 
-	err = c.ValidateGeneral(generalConfig, generalKeyOrdering, flags)
+	err = c.ValidateInputs(generalConfig, generalKeyOrdering, authConfig, flags)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return err
 	}
 
-	jsonString := fmt.Sprintf(`{"org":"%s", "env":"%s", "action":"%s", "protocol":"%s"`,
-		*generalConfig["apigee_org"].value,
-		*generalConfig["apigee_env"].value,
-		*generalConfig["action"].value,
-		*generalConfig["protocol"].value,
-	)
+	err = c.validateResolved(cliConnection, instance, *generalConfig["service"].value, plan, true)
+	if err != nil {
+		return err
+	}
 
-	if isMicroPlan {
-		jsonString = fmt.Sprintf(`%s, "micro":"%s"`, jsonString, *generalConfig["micro"].value)
-	} else if *generalConfig["host"].value != "" {
-		jsonString = fmt.Sprintf(`%s, "host":"%s"`, jsonString, *generalConfig["host"].value)
+	actions, err := ParseActions(*generalConfig["action"].value)
+	if err != nil {
+		return err
+	}
+
+	route, err := c.ResolveRoute(cliConnection, *generalConfig["app"].value, *routeConfig["route"].value, *routeConfig["domain"].value)
+	if err != nil {
+		return err
 	}
 
-	if *authConfig["bearer"].value != "" {
-		jsonString = fmt.Sprintf(`%s, "bearer":"%s"}`, jsonString, *authConfig["bearer"].value)
+	auth := NewAuthParams(*authConfig["bearer"].value, *authConfig["basic"].value, *authConfig["user"].value, *authConfig["pass"].value)
+	var params interface{}
+	if isMicroPlan {
+		params = MicroBindParams{
+			Org:        *generalConfig["apigee_org"].value,
+			Env:        *generalConfig["apigee_env"].value,
+			Action:     actions.String(),
+			Protocol:   *generalConfig["protocol"].value,
+			Micro:      *generalConfig["micro"].value,
+			AuthParams: auth,
+		}
 	} else {
-		jsonString = fmt.Sprintf(`%s, "user":"%s", "pass":"%s"}`, jsonString, *authConfig["user"].value, *authConfig["pass"].value)
+		params = OrgBindParams{
+			Org:        *generalConfig["apigee_org"].value,
+			Env:        *generalConfig["apigee_env"].value,
+			Action:     actions.String(),
+			Protocol:   *generalConfig["protocol"].value,
+			Host:       *generalConfig["host"].value,
+			AuthParams: auth,
+		}
 	}
 
-	commandArgs := []string{"bind-route-service", *generalConfig["domain"].value, *generalConfig["service"].value, "--hostname", *generalConfig["app"].value, "-c", jsonString}
-	_, err = cliConnection.CliCommand(commandArgs...)
+	jsonString, err := MarshalBindParams(params)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return err
 	}
 
+	return c.CfBindCommand(cliConnection, jsonString, route.RouteServiceArgs("bind-route-service", *generalConfig["service"].value)...)
 }
 
-//ApigeeBindServiceCommand is responsible for binding an app to a service instance of the coresident plan
-func (c *ApigeeBrokerPlugin) ApigeeBindServiceCommand(cliConnection plugin.CliConnection, args []string) {
-	flags := flag.NewFlagSet("apigee-bind", flag.ExitOnError)
+//ApigeeBindServiceCommand is responsible for binding an app to a service instance of the coresident plan. instance,
+//when not nil, is the service instance ResolvePlan looked up
+func (c *ApigeeBrokerPlugin) ApigeeBindServiceCommand(cliConnection plugin.CliConnection, args []string, instance *plugin_models.GetService_Model) error {
+	flags := flag.NewFlagSet("apigee-bind", flag.ContinueOnError)
+	flags.SetOutput(c.Out)
 	generalConfig := map[string]UserInput{
 		"service": UserInput{
 			value:         flags.String("service", "", "Service instance name to bind to [required]: "),
@@ -373,7 +650,7 @@ func (c *ApigeeBrokerPlugin) ApigeeBindServiceCommand(cliConnection plugin.CliCo
 			hiddenInput:   false,
 		},
 		"action": UserInput{
-			value:         flags.String("action", "", "Action to take (\"bind\", \"proxy bind\", or \"proxy\") [required]: "),
+			value:         actionFlag(flags, "Action to take (\"bind\", \"proxy bind\", or \"proxy\") [required]: "),
 			requiredInput: true,
 			hiddenInput:   false,
 		},
@@ -390,6 +667,11 @@ func (c *ApigeeBrokerPlugin) ApigeeBindServiceCommand(cliConnection plugin.CliCo
 			requiredInput: false,
 			hiddenInput:   true,
 		},
+		"basic": UserInput{
+			value:         flags.String("basic", "", "Apigee basic authentication credentials: "),
+			requiredInput: false,
+			hiddenInput:   true,
+		},
 		"pass": UserInput{
 			value:         flags.String("pass", "", "Apigee password: "),
 			requiredInput: true,
@@ -402,81 +684,110 @@ func (c *ApigeeBrokerPlugin) ApigeeBindServiceCommand(cliConnection plugin.CliCo
 		},
 	}
 
+	nonInteractive := flags.Bool("non-interactive", false, "Fail instead of prompting for missing values")
+	profile := flags.String("profile", "", "Name of the profile to fill unset flags from")
+	credentialSources := AddCredentialFlags(flags)
+	start := flags.Bool("start", false, "Start the application after binding")
+	noStart := flags.Bool("no-start", false, "Do not start the application after binding")
+
 	//Parse from [1] since [0] is command name
 	err := flags.Parse(args[1:])
+	if err == flag.ErrHelp {
+		return nil
+	}
 	if err != nil {
-		fmt.Println("Error: Couldn't parse arguments: ", err)
-		os.Exit(1)
+		return newCommandErrorf(ExitUsage, "Error: Couldn't parse arguments: %s", err.Error())
 	}
 
 	// Check to make sure there are no extra arguments
 	if flags.NArg() > 0 {
-		fmt.Println("Error: Unknown extra arguments")
-		os.Exit(1)
+		return newCommandErrorf(ExitUsage, "Error: Unknown extra arguments")
+	}
+	c.SetNonInteractive(*nonInteractive)
+
+	err = c.ApplyProfile(*profile, generalConfig, flags)
+	if err != nil {
+		return err
+	}
+
+	err = c.SuggestService(cliConnection, generalConfig["service"].value, PlanCoresident)
+	if err != nil {
+		return err
+	}
+
+	if *start && *noStart {
+		return newCommandErrorf(ExitUsage, "Error: Only one of --start and --no-start can be set")
 	}
 
 	// Get consistent argument ordering for user prompt (based on lexigraphical order)
 	generalKeyOrdering := make([]string, 0)
 	visitor := func(f *flag.Flag) {
-		if _, ok := authConfig[f.Name]; !ok {
+		if _, ok := generalConfig[f.Name]; ok {
 			generalKeyOrdering = append(generalKeyOrdering, f.Name)
 		}
 	}
 	flags.VisitAll(visitor)
 
-	err = c.ValidateAuth(authConfig, flags)
+	err = c.ResolveCredentials(authConfig, credentialSources)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return err
 	}
 
-	err = c.ValidateGeneral(generalConfig, generalKeyOrdering, flags)
+	err = c.ValidateInputs(generalConfig, generalKeyOrdering, authConfig, flags)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-
-	jsonString := fmt.Sprintf(`{"org":"%s", "env":"%s", "action":"%s", "target_app_route":"%s", "target_app_port":"%s", "edgemicro_key":"%s", "edgemicro_secret":"%s"`,
-		*generalConfig["apigee_org"].value,
-		*generalConfig["apigee_env"].value,
-		*generalConfig["action"].value,
-		*generalConfig["target_app_route"].value,
-		*generalConfig["target_app_port"].value,
-		*generalConfig["edgemicro_key"].value,
-		*generalConfig["edgemicro_secret"].value,
-	)
-
-	if *authConfig["bearer"].value != "" {
-		jsonString = fmt.Sprintf(`%s, "bearer":"%s"}`, jsonString, *authConfig["bearer"].value)
-	} else {
-		jsonString = fmt.Sprintf(`%s, "user":"%s", "pass":"%s"}`, jsonString, *authConfig["user"].value, *authConfig["pass"].value)
+		return err
 	}
 
-	commandArgs := []string{"bind-service", *generalConfig["app"].value, *generalConfig["service"].value, "-c", jsonString}
-	_, err = cliConnection.CliCommand(commandArgs...)
+	err = c.validateResolved(cliConnection, instance, *generalConfig["service"].value, PlanCoresident, true)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return err
 	}
 
-	reader := bufio.NewReader(os.Stdin)
-	var start string
-	fmt.Print("Would you like to start your application now? [y/n] ")
-	tmp, _ := reader.ReadString('\n')
-	start = strings.TrimSpace(tmp)
-	if strings.ToLower(start) == "yes" || strings.ToLower(start) == "y" {
-		_, err = cliConnection.CliCommand("start", *generalConfig["app"].value)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+	actions, err := ParseActions(*generalConfig["action"].value)
+	if err != nil {
+		return err
 	}
 
+	params := CoresidentBindParams{
+		Org:             *generalConfig["apigee_org"].value,
+		Env:             *generalConfig["apigee_env"].value,
+		Action:          actions.String(),
+		TargetAppRoute:  *generalConfig["target_app_route"].value,
+		TargetAppPort:   *generalConfig["target_app_port"].value,
+		EdgemicroKey:    *generalConfig["edgemicro_key"].value,
+		EdgemicroSecret: *generalConfig["edgemicro_secret"].value,
+		AuthParams:      NewAuthParams(*authConfig["bearer"].value, *authConfig["basic"].value, *authConfig["user"].value, *authConfig["pass"].value),
+	}
+
+	jsonString, err := MarshalBindParams(params)
+	if err != nil {
+		return err
+	}
+
+	err = c.CfBindCommand(cliConnection, jsonString, "bind-service", *generalConfig["app"].value, *generalConfig["service"].value)
+	if err != nil {
+		return err
+	}
+
+	startApp := *start
+	if !*start && !*noStart && !c.nonInteractive {
+		fmt.Fprint(c.Out, "Would you like to start your application now? [y/n] ")
+		tmp, _ := c.input().ReadString('\n')
+		startResponse := strings.ToLower(strings.TrimSpace(tmp))
+		startApp = startResponse == "yes" || startResponse == "y"
+	}
+	if startApp {
+		return c.CfCommand(cliConnection, "start", *generalConfig["app"].value)
+	}
+	return nil
 }
 
-//ApigeeUnbindCommand is responsible for unbinding an application from an apigee plan based service broker
-func (c *ApigeeBrokerPlugin) ApigeeUnbindCommand(cliConnection plugin.CliConnection, args []string, isRoutePlan bool) {
-	flags := flag.NewFlagSet("apigee-route-bind", flag.ExitOnError)
+//ApigeeUnbindPlanCommand is responsible for unbinding an application from an apigee plan based service broker.
+//instance, when not nil, is the service instance ResolvePlan looked up
+func (c *ApigeeBrokerPlugin) ApigeeUnbindPlanCommand(cliConnection plugin.CliConnection, args []string, plan Plan, instance *plugin_models.GetService_Model) error {
+	isRoutePlan := plan.IsRoutePlan()
+	flags := flag.NewFlagSet("apigee-route-bind", flag.ContinueOnError)
+	flags.SetOutput(c.Out)
 	generalConfig := map[string]UserInput{
 		"service": UserInput{
 			value:         flags.String("service", "", "Service instance name to unbind from [required]: "),
@@ -490,132 +801,231 @@ func (c *ApigeeBrokerPlugin) ApigeeUnbindCommand(cliConnection plugin.CliConnect
 		},
 	}
 
+	routeConfig := make(map[string]UserInput)
 	if isRoutePlan {
-		generalConfig["domain"] = UserInput{
-			value:         flags.String("domain", "", "Domain of application to unbind from [required]: "),
-			requiredInput: true,
-			hiddenInput:   false,
+		routeConfig["route"] = UserInput{
+			value: flags.String("route", "", "Route of the application to unbind, as host.domain/path"),
+		}
+		routeConfig["domain"] = UserInput{
+			value: flags.String("domain", "", "Domain of the application route to unbind"),
 		}
 	}
 
+	nonInteractive := flags.Bool("non-interactive", false, "Fail instead of prompting for missing values")
+	profile := flags.String("profile", "", "Name of the profile to fill unset flags from")
+
 	// Parse from [1] since [0] is command name
 	err := flags.Parse(args[1:])
+	if err == flag.ErrHelp {
+		return nil
+	}
 	if err != nil {
-		fmt.Println("Error: Couldn't parse arguments: ", err)
-		os.Exit(1)
+		return newCommandErrorf(ExitUsage, "Error: Couldn't parse arguments: %s", err.Error())
 	}
 
 	// Check to make sure there are no extra arguments
 	if flags.NArg() > 0 {
-		fmt.Println("Error: Unknown extra arguments")
-		os.Exit(1)
+		return newCommandErrorf(ExitUsage, "Error: Unknown extra arguments")
+	}
+	c.SetNonInteractive(*nonInteractive)
+
+	err = c.ApplyProfile(*profile, mergeConfigs(generalConfig, routeConfig), flags)
+	if err != nil {
+		return err
+	}
+
+	err = c.SuggestService(cliConnection, generalConfig["service"].value, plan)
+	if err != nil {
+		return err
 	}
 
 	//Get consistent argument ordering for user prompt (based on lexigraphical order)
 	generalKeyOrdering := make([]string, 0)
 	visitor := func(f *flag.Flag) {
-		generalKeyOrdering = append(generalKeyOrdering, f.Name)
+		if _, ok := generalConfig[f.Name]; ok {
+			generalKeyOrdering = append(generalKeyOrdering, f.Name)
+		}
 	}
 	flags.VisitAll(visitor)
 
-	err = c.ValidateGeneral(generalConfig, generalKeyOrdering, flags)
+	err = c.ValidateInputs(generalConfig, generalKeyOrdering, nil, flags)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return err
+	}
+
+	err = c.validateResolved(cliConnection, instance, *generalConfig["service"].value, plan, false)
+	if err != nil {
+		return err
 	}
 
 	commandArgs := make([]string, 0)
 	if isRoutePlan {
-		commandArgs = append(commandArgs, "unbind-route-service", *generalConfig["domain"].value, *generalConfig["service"].value, "--hostname", *generalConfig["app"].value)
+		route, err := c.ResolveRoute(cliConnection, *generalConfig["app"].value, *routeConfig["route"].value, *routeConfig["domain"].value)
+		if err != nil {
+			return err
+		}
+		commandArgs = append(commandArgs, route.RouteServiceArgs("unbind-route-service", *generalConfig["service"].value)...)
 	} else {
 		commandArgs = append(commandArgs, "unbind-service", *generalConfig["app"].value, *generalConfig["service"].value)
 	}
-	_, err = cliConnection.CliCommand(commandArgs...)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-
+	return c.CfCommand(cliConnection, commandArgs...)
 }
 
 //ApigeePushCommand is responsible for pushing an application to cloud foundry. This is especially important for java developers
 //as it allows for adding the necessary plugin and config directories to their archive
-func (c *ApigeeBrokerPlugin) ApigeePushCommand(cliConnection plugin.CliConnection, args []string) {
-	reader := bufio.NewReader(os.Stdin)
-	flags := flag.NewFlagSet("apigee-push", flag.ExitOnError)
+func (c *ApigeeBrokerPlugin) ApigeePushCommand(cliConnection plugin.CliConnection, args []string) error {
+	flags := flag.NewFlagSet("apigee-push", flag.ContinueOnError)
+	flags.SetOutput(c.Out)
 	config := flags.String("config", "", "Path to configuration directory that contains a microgateway yaml [required]: ")
 	plugins := flags.String("plugins", "", "Path to configuration directory that contains custom plugins [optional]: ")
 	archive := flags.String("archive", "", "If you are pushing a java application, enter the path to the archive. Otherwise press [Enter]: ")
+	path := flags.String("path", "", "Path to the application directory, a copy of which is pushed with the config and plugins directories added")
 	app := flags.String("app", "", "Specific name of application to push [optional]: ")
+	coresident := flags.Bool("coresident", false, "The application will be used with the microgateway-coresident plan")
+	nonInteractive := flags.Bool("non-interactive", false, "Fail instead of prompting for missing values")
+	maxSize := flags.Int64("max-extracted-size", DefaultExtractLimits.MaxTotalSize>>20, "Most megabytes the archive may extract to, 0 for no limit")
+	maxEntries := flags.Int("max-entries", DefaultExtractLimits.MaxEntries, "Most entries the archive may contain, 0 for no limit")
+	maxRatio := flags.Int64("max-ratio", DefaultExtractLimits.MaxRatio, "Most a file in the archive may be compressed, 0 for no limit")
+	symlinks := flags.String("symlinks", SymlinkPreserve.String(), "What to do with symbolic links in the application directory and the directories added: \"preserve\", \"follow\" or \"reject\"")
+	reproducible := flags.Bool("reproducible", c.inCI(), "Write the same archive byte for byte from the same inputs, and print its SHA-256")
+	exclude := excludeFlag(flags, "Pattern, as in .cfignore, of files in the config and plugins directories to leave out. Can be repeated")
+	archivePrefix := flags.String("archive-prefix", "", "Directory in the archive to add the config and plugins directories to, instead of the one for its layout")
+	verifyOnly := flags.Bool("verify-only", false, "Check the decorated copy of --archive written before against it and the config and plugins directories, without pushing")
 
 	// Parse from [1] since [0] is command name
 	err := flags.Parse(args[1:])
+	if err == flag.ErrHelp {
+		return nil
+	}
 	if err != nil {
-		fmt.Println("Error: Couldn't parse arguments: ", err)
-		os.Exit(1)
+		return newCommandErrorf(ExitUsage, "Error: Couldn't parse arguments: %s", err.Error())
 	}
 
 	// Check to make sure there are no extra arguments
 	if flags.NArg() > 0 {
-		fmt.Println("Error: Unknown extra arguments")
-		os.Exit(1)
+		return newCommandErrorf(ExitUsage, "Error: Unknown extra arguments")
+	}
+	if *maxSize < 0 || *maxEntries < 0 || *maxRatio < 0 {
+		return newCommandErrorf(ExitUsage, "Error: --max-extracted-size, --max-entries and --max-ratio cannot be negative")
+	}
+	limits := ExtractLimits{MaxTotalSize: *maxSize << 20, MaxEntries: *maxEntries, MaxRatio: *maxRatio}
+	prefix, err := ParseArchivePrefix(*archivePrefix)
+	if err != nil {
+		return err
+	}
+	policy, err := ParseSymlinkPolicy(*symlinks)
+	if err != nil {
+		return err
+	}
+	prefixSet := false
+	flags.Visit(func(f *flag.Flag) {
+		prefixSet = prefixSet || f.Name == "archive-prefix"
+	})
+	var modTime time.Time
+	if *reproducible {
+		modTime, err = c.reproducibleModTime()
+		if err != nil {
+			return err
+		}
+	}
+	c.SetNonInteractive(*nonInteractive)
+	rewriteOptions := RewriteOptions{
+		Dirs:         []string{*config, *plugins},
+		Prefix:       prefix,
+		Symlinks:     policy,
+		Exclude:      *exclude,
+		Limits:       limits,
+		Reproducible: *reproducible,
+		ModTime:      modTime,
+	}
+
+	// Verifying checks the archive written by an earlier apigee-push, which needs no prompts and pushes nothing
+	if *verifyOnly {
+		if *path != "" {
+			return newCommandErrorf(ExitUsage, "Error: --verify-only checks an archive and cannot be used with --path")
+		}
+		if *archive == "" || *config == "" {
+			return newCommandErrorf(ExitUsage, "Error: --verify-only needs the original --archive and the --config directory it was decorated with")
+		}
+		rewriteOptions, err = c.archiveOptions(*archive, rewriteOptions, prefixSet)
+		if err != nil {
+			return err
+		}
+		return c.verifyArchive(*archive, rewriteOptions)
+	}
+
+	if *archive != "" && *path != "" {
+		return newCommandErrorf(ExitUsage, "Error: --archive and --path cannot be used together")
+	}
+	// The config and plugins directories are only added for the microgateway-coresident plan, so giving them asks for it
+	if !*coresident && (*config != "" || *plugins != "") {
+		fmt.Fprintln(c.Out, "Pushing for the \"microgateway-coresident\" plan, as --config or --plugins was given")
+		*coresident = true
 	}
 
-	var coresResponse string
 	pushNoStart := false
-	fmt.Print("Do you plan on using this application with the \"microgateway-coresident\" plan? [y/n] ")
-	tmp, _ := reader.ReadString('\n')
-	coresResponse = strings.ToLower(strings.TrimSpace(tmp))
+	if !*coresident && !c.nonInteractive {
+		fmt.Fprint(c.Out, "Do you plan on using this application with the \"microgateway-coresident\" plan? [y/n] ")
+		tmp, _ := c.input().ReadString('\n')
+		coresResponse := strings.ToLower(strings.TrimSpace(tmp))
+		*coresident = coresResponse == "y" || coresResponse == "yes"
+	}
 
 	// Only give option for archive changes if coresident is the planned course of action
-	if coresResponse == "y" || coresResponse == "yes" {
+	if *coresident {
 		pushNoStart = true
-		if *archive == "" {
-			fmt.Print(flags.Lookup("archive").Usage)
-			tmp, _ = reader.ReadString('\n')
+		if *archive == "" && !c.nonInteractive {
+			fmt.Fprint(c.Out, flags.Lookup("archive").Usage)
+			tmp, _ := c.input().ReadString('\n')
 			*archive = strings.TrimSpace(tmp)
 		}
 		if *archive != "" {
+			if info, err := os.Stat(*archive); err == nil && info.IsDir() {
+				*path = *archive
+				*archive = ""
+			}
+		}
+		if *archive != "" && *path != "" {
+			return newCommandErrorf(ExitUsage, "Error: --archive and --path cannot be used together")
+		}
+		if *archive != "" || *path != "" {
 			if *config == "" {
-				fmt.Print(flags.Lookup("config").Usage)
-				tmp, _ = reader.ReadString('\n')
+				if c.nonInteractive {
+					return missingInputError([]string{"--config"})
+				}
+				fmt.Fprint(c.Out, flags.Lookup("config").Usage)
+				tmp, _ := c.input().ReadString('\n')
 				*config = strings.TrimSpace(tmp)
 				err = c.CheckEmpty("config", *config)
 				if err != nil {
-					fmt.Println(err)
-					os.Exit(1)
+					return err
 				}
 			}
-			if *plugins == "" {
-				fmt.Print(flags.Lookup("plugins").Usage)
-				tmp, _ = reader.ReadString('\n')
+			if *plugins == "" && !c.nonInteractive {
+				fmt.Fprint(c.Out, flags.Lookup("plugins").Usage)
+				tmp, _ := c.input().ReadString('\n')
 				*plugins = strings.TrimSpace(tmp)
 			}
 
-			tempDir, err := ioutil.TempDir("", "tmp_archive")
-			if err != nil {
-				fmt.Println("Error making temp directory: ", err)
-				os.Exit(1)
-			}
-			defer os.RemoveAll(tempDir) // clean up
-
-			err = Extract(tempDir, *archive, *config, *plugins)
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+			if *path != "" {
+				*path, err = c.stageApp(*path, []string{*config, *plugins}, policy, *exclude)
+				if err == nil && !c.dryRun {
+					// cf push has uploaded the copy by the time the command returns
+					defer c.removeTemp(*path)
+				}
+			} else {
+				rewriteOptions.Dirs = []string{*config, *plugins}
+				*archive, err = c.decorateArchive(*archive, rewriteOptions, prefixSet)
 			}
-			destination := filepath.Join(filepath.Dir(*archive), "apigee_"+filepath.Base(*archive))
-			*archive, err = Compress(tempDir, destination)
 			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+				return err
 			}
 		}
 	}
 
-	if *app == "" {
-		fmt.Print(flags.Lookup("app").Usage)
-		tmp, _ = reader.ReadString('\n')
+	if *app == "" && !c.nonInteractive {
+		fmt.Fprint(c.Out, flags.Lookup("app").Usage)
+		tmp, _ := c.input().ReadString('\n')
 		*app = strings.TrimSpace(tmp)
 	}
 
@@ -626,50 +1036,217 @@ func (c *ApigeeBrokerPlugin) ApigeePushCommand(cliConnection plugin.CliConnectio
 	}
 	if *archive != "" {
 		commandArgs = append(commandArgs, "-p", *archive)
+	} else if *path != "" {
+		commandArgs = append(commandArgs, "-p", *path)
 	}
 	if pushNoStart {
 		commandArgs = append(commandArgs, "--no-start")
 	}
 
-	_, err = cliConnection.CliCommand(commandArgs...)
+	return c.CfCommand(cliConnection, commandArgs...)
+}
+
+//archiveOptions completes options for archive, putting the directories where the archive's layout puts them unless
+//prefixSet says options.Prefix was given
+func (c *ApigeeBrokerPlugin) archiveOptions(archive string, options RewriteOptions, prefixSet bool) (RewriteOptions, error) {
+	layout, err := DetectLayout(archive)
+	if err != nil {
+		return options, NewCommandError(ExitArchive, err)
+	}
+	if !prefixSet {
+		options.Prefix = layout.Prefix
+	}
+	fmt.Fprintf(c.Out, "Archive layout of %s: %s, adding the microgateway directories %s\n", archive, layout.Name, describePrefix(options.Prefix))
+	if options.Prefix != "" {
+		config, plugins := options.Dirs[0], options.Dirs[1]
+		fmt.Fprintf(c.Out, "Set APIGEE_MICROGATEWAY_CONFIG_DIR to %s%s in the application's environment", options.Prefix, filepath.Base(config))
+		if plugins != "" {
+			fmt.Fprintf(c.Out, " and APIGEE_MICROGATEWAY_CUST_PLUGINS to %s%s", options.Prefix, filepath.Base(plugins))
+		}
+		fmt.Fprintln(c.Out)
+	}
+	return options, nil
+}
+
+//decoratedPath is where the copy of archive with the microgateway directories added is written
+func decoratedPath(archive string) string {
+	return filepath.Join(filepath.Dir(archive), "apigee_"+filepath.Base(archive))
+}
+
+//verifyArchive checks the decorated copy of archive with VerifyArchive
+func (c *ApigeeBrokerPlugin) verifyArchive(archive string, options RewriteOptions) error {
+	destination := decoratedPath(archive)
+	entries, err := VerifyArchive(c.context(), destination, archive, options)
+	if err != nil {
+		return NewCommandError(ExitArchive, err)
+	}
+	fmt.Fprintf(c.Out, "Verified %s: %d entries match %s and the microgateway directories\n", destination, entries, archive)
+	return nil
+}
+
+//decorateArchive writes a copy of archive with options applied, and verifies it, returning the copy's path. The
+//directories go where the archive's layout puts them, unless prefixSet says options.Prefix was given. In a dry run it
+//only lists what would be added
+func (c *ApigeeBrokerPlugin) decorateArchive(archive string, options RewriteOptions, prefixSet bool) (string, error) {
+	options, err := c.archiveOptions(archive, options, prefixSet)
+	if err != nil {
+		return "", err
+	}
+
+	destination := decoratedPath(archive)
+	if c.dryRun {
+		additions, skipped, err := ArchiveAdditions(c.context(), archive, options)
+		if err != nil {
+			return "", NewCommandError(ExitArchive, err)
+		}
+		fmt.Fprintf(c.Out, "Would write %s with these entries added to %s:\n", destination, archive)
+		for _, entry := range additions {
+			fmt.Fprintf(c.Out, "  %s\n", entry)
+		}
+		if skipped.Files > 0 {
+			fmt.Fprintf(c.Out, "Would leave out %s of the microgateway directories\n", skipped)
+		}
+		return destination, nil
+	}
 
+	// A partially written archive, or one failing verification, is removed if rewriting fails or is interrupted
+	c.trackTemp(destination)
+	skipped, err := RewriteArchive(c.context(), archive, destination, options)
+	if err != nil {
+		return "", NewCommandError(ExitArchive, err)
+	}
+	err = c.verifyArchive(archive, options)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return "", err
+	}
+	c.untrackTemp(destination)
+	if skipped.Files > 0 {
+		fmt.Fprintf(c.Out, "Left out %s of the microgateway directories matching ignore patterns\n", skipped)
+	}
+	if options.Reproducible {
+		sum, err := FileSHA256(destination)
+		if err != nil {
+			return "", NewCommandError(ExitArchive, err)
+		}
+		fmt.Fprintf(c.Out, "SHA-256 of %s: %s\n", destination, sum)
 	}
+	return destination, nil
 }
 
 /*Helpers*/
 
+//SetNonInteractive turns off all prompting when requested by the user or when stdin is not a terminal
+func (c *ApigeeBrokerPlugin) SetNonInteractive(nonInteractive bool) {
+	c.nonInteractive = nonInteractive || !c.IsTerminal()
+}
+
+//input returns a buffered reader over In that is shared by every prompt, once the prompt has been printed
+func (c *ApigeeBrokerPlugin) input() *bufio.Reader {
+	c.flushOut()
+	if c.reader == nil {
+		c.reader = bufio.NewReader(c.In)
+	}
+	return c.reader
+}
+
+//readPassword reads a hidden value with ReadPassword once the prompt has been printed
+func (c *ApigeeBrokerPlugin) readPassword() ([]byte, error) {
+	c.flushOut()
+	return c.ReadPassword()
+}
+
+//flushOut passes on what Out holds back, such as a prompt without a newline
+func (c *ApigeeBrokerPlugin) flushOut() {
+	if flusher, ok := c.Out.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+}
+
+//mergeConfigs combines flag configurations into one map. The values are shared, so setting a value in the result
+//sets it in the original configuration
+func mergeConfigs(configs ...map[string]UserInput) map[string]UserInput {
+	merged := make(map[string]UserInput)
+	for _, config := range configs {
+		for key, input := range config {
+			merged[key] = input
+		}
+	}
+	return merged
+}
+
+//ValidateInputs makes sure every required value has been provided, prompting for missing values unless running
+//non-interactively. authConfig may be nil for commands that do not authenticate with Apigee
+func (c *ApigeeBrokerPlugin) ValidateInputs(generalConfig map[string]UserInput, generalKeyOrdering []string, authConfig map[string]UserInput, flags *flag.FlagSet) error {
+	defer c.redactor.AddInputs(generalConfig, authConfig)
+	if c.nonInteractive {
+		return c.CheckMissing(generalConfig, generalKeyOrdering, authConfig)
+	}
+	if authConfig != nil {
+		err := c.ValidateAuth(authConfig, flags)
+		if err != nil {
+			return err
+		}
+	}
+	return c.ValidateGeneral(generalConfig, generalKeyOrdering, flags)
+}
+
+//CheckMissing returns an error listing every required flag that has not been set
+func (c *ApigeeBrokerPlugin) CheckMissing(generalConfig map[string]UserInput, generalKeyOrdering []string, authConfig map[string]UserInput) error {
+	missing := make([]string, 0)
+	if authConfig != nil && *authConfig["bearer"].value == "" && *authConfig["basic"].value == "" {
+		credentials := make([]string, 0)
+		for _, key := range []string{"user", "pass"} {
+			if *authConfig[key].value == "" {
+				credentials = append(credentials, "--"+key)
+			}
+		}
+		if len(credentials) > 0 {
+			missing = append(missing, strings.Join(credentials, " and ")+" (or --bearer)")
+		}
+	}
+	for _, key := range generalKeyOrdering {
+		input := generalConfig[key]
+		if input.requiredInput && *input.value == "" {
+			missing = append(missing, "--"+key)
+		}
+	}
+	if len(missing) > 0 {
+		return missingInputError(missing)
+	}
+	return nil
+}
+
+//missingInputError lists the flags that must be provided when prompting is not possible
+func missingInputError(flagNames []string) error {
+	return newCommandErrorf(ExitMissingInput, "Running non-interactively and missing required values for: %s. Exiting", strings.Join(flagNames, ", "))
+}
+
 //CheckEmpty checks if a variable is empty and returns an error if so
 func (c *ApigeeBrokerPlugin) CheckEmpty(name string, flagValue string) error {
 	if flagValue == "" {
-		errorMsg := fmt.Sprintf("Did not set the required value for \"%s\". Exiting", name)
-		return errors.New(errorMsg)
+		return newCommandErrorf(ExitMissingInput, "Did not set the required value for \"%s\". Exiting", name)
 	}
 	return nil
 }
 
 //ValidateGeneral prompts the user for information regarding any missing flag values
 func (c *ApigeeBrokerPlugin) ValidateGeneral(generalConfig map[string]UserInput, generalKeyOrdering []string, flags *flag.FlagSet) error {
-	reader := bufio.NewReader(os.Stdin)
 	for _, key := range generalKeyOrdering {
 		input := generalConfig[key]
 		if *input.value == "" {
 			var flagValue string
 			if input.hiddenInput {
-				fmt.Print(flags.Lookup(key).Usage)
-				tmp, err := terminal.ReadPassword(int(syscall.Stdin))
+				fmt.Fprint(c.Out, flags.Lookup(key).Usage)
+				tmp, err := c.readPassword()
 				if err != nil {
-					errorMsg := fmt.Sprintf("Error reading in hidden value: %s" + err.Error())
-					return errors.New(errorMsg)
+					return newCommandErrorf(ExitMissingInput, "Error reading in hidden value: %s", err.Error())
 				}
 				flagValue = string(tmp)
 				// Print new line after receiving hidden input
-				fmt.Println()
+				fmt.Fprintln(c.Out)
 			} else {
-				fmt.Print(flags.Lookup(key).Usage)
-				flagValue, _ = reader.ReadString('\n')
+				fmt.Fprint(c.Out, flags.Lookup(key).Usage)
+				flagValue, _ = c.input().ReadString('\n')
 				flagValue = strings.TrimSpace(flagValue)
 			}
 			if input.requiredInput {
@@ -687,30 +1264,28 @@ func (c *ApigeeBrokerPlugin) ValidateGeneral(generalConfig map[string]UserInput,
 
 //ValidateAuth promts the user for necessary authentication values if they have not been provided
 func (c *ApigeeBrokerPlugin) ValidateAuth(authConfig map[string]UserInput, flags *flag.FlagSet) error {
-	reader := bufio.NewReader(os.Stdin)
 	// Check for user an pass first before asking for bearer
 	if *authConfig["user"].value == "" || *authConfig["pass"].value == "" {
-		if *authConfig["bearer"].value == "" {
+		if *authConfig["bearer"].value == "" && *authConfig["basic"].value == "" {
 			// Due to terminal input buffer size limitations, we can't take in a large token via prompt
 			// If user wishes to use bearer, they must provide it in the command itself
 			var bearerResponse string
-			fmt.Print("Are you using a bearer token to authenticate with Apigee Edge? [y/n] ")
-			tmp, _ := reader.ReadString('\n')
+			fmt.Fprint(c.Out, "Are you using a bearer token to authenticate with Apigee Edge? [y/n] ")
+			tmp, _ := c.input().ReadString('\n')
 			bearerResponse = strings.ToLower(strings.TrimSpace(tmp))
 			if bearerResponse == "y" || bearerResponse == "yes" {
-				fmt.Print("Note: Authenticating by bearer token requires passing the token through the [--bearer APIGEE_BEARER_TOKEN] option.\nDo you wish exit this prompt and continue authenticating via bearer token? [y/n] ")
-				tmp, _ = reader.ReadString('\n')
+				fmt.Fprint(c.Out, "Note: Authenticating by bearer token requires passing the token through the [--bearer APIGEE_BEARER_TOKEN] option or running \"cf apigee-login\" first.\nDo you wish exit this prompt and continue authenticating via bearer token? [y/n] ")
+				tmp, _ = c.input().ReadString('\n')
 				bearerResponse = strings.ToLower(strings.TrimSpace(tmp))
 				if bearerResponse == "y" || bearerResponse == "yes" {
-					errorMsg := "Autenticating through bearer token. Please provide the bearer token via the [--bearer APIGEE_BEARER_TOKEN] option when running this command again."
-					return errors.New(errorMsg)
+					return newCommandErrorf(ExitCancelled, "Autenticating through bearer token. Please provide the bearer token via the [--bearer APIGEE_BEARER_TOKEN] option, or log in with \"cf apigee-login\", before running this command again.")
 				}
 			}
 			if bearerResponse == "n" || bearerResponse == "no" {
-				fmt.Println("Bearer authentication not selected, authenticating with username and password")
+				fmt.Fprintln(c.Out, "Bearer authentication not selected, authenticating with username and password")
 				if *authConfig["user"].value == "" {
-					fmt.Print(flags.Lookup("user").Usage)
-					tmp, _ := reader.ReadString('\n')
+					fmt.Fprint(c.Out, flags.Lookup("user").Usage)
+					tmp, _ := c.input().ReadString('\n')
 					*authConfig["user"].value = strings.ToLower(strings.TrimSpace(tmp))
 					err := c.CheckEmpty("user", *authConfig["user"].value)
 					if err != nil {
@@ -718,11 +1293,10 @@ func (c *ApigeeBrokerPlugin) ValidateAuth(authConfig map[string]UserInput, flags
 					}
 				}
 				if *authConfig["pass"].value == "" {
-					fmt.Print(flags.Lookup("pass").Usage)
-					tmp, err := terminal.ReadPassword(int(syscall.Stdin))
+					fmt.Fprint(c.Out, flags.Lookup("pass").Usage)
+					tmp, err := c.readPassword()
 					if err != nil {
-						errorMsg := fmt.Sprintf("Error reading password: %s", err.Error())
-						return errors.New(errorMsg)
+						return newCommandErrorf(ExitAuth, "Error reading password: %s", err.Error())
 					}
 					*authConfig["pass"].value = string(tmp)
 					err = c.CheckEmpty("pass", *authConfig["pass"].value)
@@ -730,11 +1304,10 @@ func (c *ApigeeBrokerPlugin) ValidateAuth(authConfig map[string]UserInput, flags
 						return err
 					}
 					// Print new line after receiving hidden input
-					fmt.Println()
+					fmt.Fprintln(c.Out)
 				}
 			} else {
-				errorMsg := fmt.Sprintf("Option configuration cancelled. Exiting")
-				return errors.New(errorMsg)
+				return newCommandErrorf(ExitCancelled, "Option configuration cancelled. Exiting")
 			}
 		}
 	}
@@ -0,0 +1,582 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"code.cloudfoundry.org/cli/plugin"
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+)
+
+// fakeCliConnection records every cf command the plugin runs. Embedding the interface leaves the
+// methods the plugin does not use unimplemented
+type fakeCliConnection struct {
+	plugin.CliConnection
+	commands [][]string
+	errs     map[string]error
+	// apps are the applications GetApp knows about, defaulting to testApps
+	apps map[string]plugin_models.GetAppModel
+	// services are the service instances in the space, defaulting to testServices
+	services []plugin_models.GetServices_Model
+	// curl maps the paths given to "cf curl" to their responses
+	curl map[string]string
+	// paramsFiles are the bind parameter files given with "-c", which are recorded in commands by their contents
+	paramsFiles []string
+	// onCommand, when set, is called with the arguments of every command before it is recorded
+	onCommand func(args []string)
+	// lookups are the service instances GetService was asked for, in order
+	lookups []string
+}
+
+// testServices has one Apigee service instance of each plan, apart from microgateway-coresident which has two, and a
+// user-provided service
+var testServices = []plugin_models.GetServices_Model{
+	testService("org-svc", ServiceOffering, PlanOrg),
+	testService("mg-svc", ServiceOffering, PlanMicro),
+	testService("mgc-svc", ServiceOffering, PlanCoresident),
+	testService("other-svc", ServiceOffering, PlanCoresident),
+	{Name: "ups", IsUserProvided: true},
+	testService("db", "p-mysql", "100mb"),
+}
+
+func testService(name, offering string, plan Plan) plugin_models.GetServices_Model {
+	return plugin_models.GetServices_Model{
+		Name:        name,
+		Guid:        name + "-guid",
+		Service:     plugin_models.GetServices_ServiceFields{Name: offering},
+		ServicePlan: plugin_models.GetServices_ServicePlan{Name: string(plan)},
+	}
+}
+
+// testApps has an application with a single route and one with routes on several domains
+var testApps = map[string]plugin_models.GetAppModel{
+	"myapp": {Name: "myapp", Routes: []plugin_models.GetApp_RouteSummary{
+		{Host: "myapp", Domain: plugin_models.GetApp_DomainFields{Name: "apps.example.com"}},
+	}},
+	"multi": {Name: "multi", Routes: []plugin_models.GetApp_RouteSummary{
+		{Host: "multi", Domain: plugin_models.GetApp_DomainFields{Name: "apps.example.com"}},
+		{Host: "multi", Domain: plugin_models.GetApp_DomainFields{Name: "apps.internal"}},
+		{Host: "api", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}, Path: "/v1"},
+		{Domain: plugin_models.GetApp_DomainFields{Name: "tcp.example.com"}, Port: 1024},
+	}},
+}
+
+func (f *fakeCliConnection) CliCommand(args ...string) ([]string, error) {
+	if f.onCommand != nil {
+		f.onCommand(args)
+	}
+	recorded := append([]string{}, args...)
+	for i := 1; i < len(args); i++ {
+		if args[i-1] == "-c" {
+			data, err := ioutil.ReadFile(args[i])
+			if err != nil {
+				return nil, err
+			}
+			f.paramsFiles = append(f.paramsFiles, args[i])
+			recorded[i] = string(data)
+		}
+	}
+	f.commands = append(f.commands, recorded)
+	return nil, f.errs[args[0]]
+}
+
+func (f *fakeCliConnection) GetApp(name string) (plugin_models.GetAppModel, error) {
+	apps := f.apps
+	if apps == nil {
+		apps = testApps
+	}
+	app, ok := apps[name]
+	if !ok {
+		return plugin_models.GetAppModel{}, errors.New("App " + name + " not found")
+	}
+	return app, nil
+}
+
+func (f *fakeCliConnection) GetServices() ([]plugin_models.GetServices_Model, error) {
+	if f.services == nil {
+		return testServices, nil
+	}
+	return f.services, nil
+}
+
+func (f *fakeCliConnection) GetService(name string) (plugin_models.GetService_Model, error) {
+	f.lookups = append(f.lookups, name)
+	services, _ := f.GetServices()
+	for _, service := range services {
+		if service.Name == name {
+			return plugin_models.GetService_Model{
+				Name:            service.Name,
+				IsUserProvided:  service.IsUserProvided,
+				ServiceOffering: plugin_models.GetService_ServiceFields{Name: service.Service.Name},
+				ServicePlan:     plugin_models.GetService_ServicePlan{Name: service.ServicePlan.Name},
+			}, nil
+		}
+	}
+	return plugin_models.GetService_Model{}, errors.New("Service instance " + name + " not found")
+}
+
+func (f *fakeCliConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	f.commands = append(f.commands, args)
+	if args[0] == "curl" {
+		response, ok := f.curl[args[1]]
+		if !ok {
+			response = `{"description":"Unknown request"}`
+		}
+		return strings.Split(response, "\n"), f.errs[args[0]]
+	}
+	return nil, f.errs[args[0]]
+}
+
+func (f *fakeCliConnection) GetApps() ([]plugin_models.GetAppsModel, error) {
+	apps := make([]plugin_models.GetAppsModel, 0)
+	for _, app := range testApps {
+		model := plugin_models.GetAppsModel{Name: app.Name, Guid: app.Name + "-guid"}
+		for _, route := range app.Routes {
+			model.Routes = append(model.Routes, plugin_models.GetAppsRouteSummary{
+				Host:   route.Host,
+				Domain: plugin_models.GetAppsDomainFields{Name: route.Domain.Name},
+			})
+		}
+		apps = append(apps, model)
+	}
+	return apps, nil
+}
+
+// newTestPlugin returns a plugin reading answers from stdin and hidden values from passwords, with
+// Exit recording the exit code
+func newTestPlugin(stdin string, passwords []string, out *bytes.Buffer, code *int) *ApigeeBrokerPlugin {
+	return &ApigeeBrokerPlugin{
+		In:  strings.NewReader(stdin),
+		Out: out,
+		ReadPassword: func() ([]byte, error) {
+			if len(passwords) == 0 {
+				return nil, errors.New("no more passwords")
+			}
+			password := passwords[0]
+			passwords = passwords[1:]
+			return []byte(password), nil
+		},
+		IsTerminal: func() bool { return true },
+		Getenv:     func(string) string { return "" },
+		Exit:       func(exit int) { *code = exit },
+	}
+}
+
+func runPlugin(conn plugin.CliConnection, args []string, stdin string, passwords ...string) (string, int) {
+	var out bytes.Buffer
+	code := ExitOK
+	newTestPlugin(stdin, passwords, &out, &code).Run(conn, args)
+	return out.String(), code
+}
+
+var coresidentArgs = []string{
+	"apigee-bind-mgc", "--app", "myapp", "--service", "mgc-svc", "--apigee_org", "myorg", "--apigee_env", "test",
+	"--edgemicro_key", "key", "--edgemicro_secret", "secret", "--target_app_route", "myapp", "--target_app_port", "8080",
+	"--action", "proxy bind",
+}
+
+func TestRunCommands(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		stdin     string
+		passwords []string
+		errs      map[string]error
+		want      [][]string
+		wantCode  int
+		wantOut   string
+	}{
+		{
+			name: "bind org plan with bearer and host",
+			args: []string{"apigee-bind-org", "--app", "myapp", "--domain", "apps.example.com", "--service", "org-svc",
+				"--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy bind", "--protocol", "https",
+				"--host", "api.example.com", "--bearer", "tok"},
+			want: [][]string{{"bind-route-service", "apps.example.com", "org-svc", "--hostname", "myapp", "-c",
+				`{"org":"myorg","env":"test","action":"proxy bind","protocol":"https","host":"api.example.com","bearer":"tok"}`}},
+		},
+		{
+			name: "bind org plan with user and pass",
+			args: []string{"apigee-bind-org", "-app", "myapp", "-domain", "apps.example.com", "-service", "org-svc",
+				"-apigee_org", "myorg", "-apigee_env", "test", "-action", "proxy", "-protocol", "http",
+				"-user", "me@example.com", "-pass", "p@ss"},
+			want: [][]string{{"bind-route-service", "apps.example.com", "org-svc", "--hostname", "myapp", "-c",
+				`{"org":"myorg","env":"test","action":"proxy","protocol":"http","user":"me@example.com","pass":"p@ss"}`}},
+		},
+		{
+			name: "bind org plan with repeated actions",
+			args: []string{"apigee-bind-org", "--app", "myapp", "--domain", "apps.example.com", "--service", "org-svc",
+				"--apigee_org", "myorg", "--apigee_env", "test", "--action", "bind", "--action", "proxy", "--bearer", "tok"},
+			want: [][]string{{"bind-route-service", "apps.example.com", "org-svc", "--hostname", "myapp", "-c",
+				`{"org":"myorg","env":"test","action":"proxy bind","protocol":"","bearer":"tok"}`}},
+		},
+		{
+			name: "bind rejects unknown actions before calling cf",
+			args: []string{"apigee-bind-org", "--app", "myapp", "--domain", "apps.example.com", "--service", "org-svc",
+				"--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy,bnid", "--bearer", "tok"},
+			wantCode: ExitUsage,
+			wantOut:  `did you mean "bind"?`,
+		},
+		{
+			name: "bind microgateway plan",
+			args: []string{"apigee-bind-mg", "--app", "myapp", "--domain", "apps.example.com", "--service", "mg-svc",
+				"--apigee_org", "myorg", "--apigee_env", "test", "--action", "bind", "--protocol", "https",
+				"--micro", "edgemicro.apps.example.com", "--bearer", "tok"},
+			want: [][]string{{"bind-route-service", "apps.example.com", "mg-svc", "--hostname", "myapp", "-c",
+				`{"org":"myorg","env":"test","action":"bind","protocol":"https","micro":"edgemicro.apps.example.com","bearer":"tok"}`}},
+		},
+		{
+			name: "bind microgateway-coresident plan and start",
+			args: append(append([]string{}, coresidentArgs...), "--bearer", "tok", "--start"),
+			want: [][]string{
+				{"bind-service", "myapp", "mgc-svc", "-c",
+					`{"org":"myorg","env":"test","action":"proxy bind","target_app_route":"myapp","target_app_port":"8080","edgemicro_key":"key","edgemicro_secret":"secret","bearer":"tok"}`},
+				{"start", "myapp"},
+			},
+		},
+		{
+			name:  "bind microgateway-coresident plan and answer the start prompt",
+			args:  append(append([]string{}, coresidentArgs...), "--user", "me", "--pass", "pw"),
+			stdin: "n\n",
+			want: [][]string{
+				{"bind-service", "myapp", "mgc-svc", "-c",
+					`{"org":"myorg","env":"test","action":"proxy bind","target_app_route":"myapp","target_app_port":"8080","edgemicro_key":"key","edgemicro_secret":"secret","user":"me","pass":"pw"}`},
+			},
+			wantOut: "Would you like to start your application now? [y/n] ",
+		},
+		{
+			name:      "bind prompts for missing credentials and values",
+			args:      []string{"apigee-bind-org", "--app", "myapp", "--domain", "apps.example.com", "--apigee_org", "myorg", "--action", "bind"},
+			stdin:     "n\nMe@Example.com\ntest\n\n\norg-svc\n",
+			passwords: []string{"pw"},
+			want: [][]string{{"bind-route-service", "apps.example.com", "org-svc", "--hostname", "myapp", "-c",
+				`{"org":"myorg","env":"test","action":"bind","protocol":"","user":"me@example.com","pass":"pw"}`}},
+			wantOut: "Apigee password: ",
+		},
+		{
+			name:     "bind fails non-interactively with missing values",
+			args:     []string{"apigee-bind-mg", "--app", "myapp", "--non-interactive"},
+			wantCode: ExitMissingInput,
+			wantOut:  "missing required values for: --user and --pass (or --bearer), --action, --apigee_env, --apigee_org, --micro",
+		},
+		{
+			name:     "bind reports cf failures",
+			args:     append(append([]string{}, coresidentArgs...), "--bearer", "tok", "--start"),
+			errs:     map[string]error{"bind-service": errors.New("broker rejected the bind")},
+			want:     [][]string{{"bind-service", "myapp", "mgc-svc", "-c", `{"org":"myorg","env":"test","action":"proxy bind","target_app_route":"myapp","target_app_port":"8080","edgemicro_key":"key","edgemicro_secret":"secret","bearer":"tok"}`}},
+			wantCode: ExitCfCommand,
+			wantOut:  "broker rejected the bind",
+		},
+		{
+			name:     "bind rejects start and no-start together",
+			args:     append(append([]string{}, coresidentArgs...), "--bearer", "tok", "--start", "--no-start"),
+			wantCode: ExitUsage,
+		},
+		{
+			name:     "bind rejects extra arguments",
+			args:     []string{"apigee-bind-org", "--app", "myapp", "extra"},
+			wantCode: ExitUsage,
+			wantOut:  "Unknown extra arguments",
+		},
+		{
+			name:     "bind rejects unknown flags",
+			args:     []string{"apigee-bind-org", "--ap", "myapp"},
+			wantCode: ExitUsage,
+			wantOut:  "Couldn't parse arguments",
+		},
+		{
+			name:     "bind cancelled at the credentials prompt",
+			args:     []string{"apigee-bind-org", "--app", "myapp"},
+			stdin:    "maybe\n",
+			wantCode: ExitCancelled,
+			wantOut:  "Option configuration cancelled",
+		},
+		{
+			name: "help is not an error",
+			args: []string{"apigee-unbind-mgc", "--help"},
+		},
+		{
+			name: "unbind org plan",
+			args: []string{"apigee-unbind-org", "--app", "myapp", "--domain", "apps.example.com", "--service", "org-svc"},
+			want: [][]string{{"unbind-route-service", "apps.example.com", "org-svc", "--hostname", "myapp"}},
+		},
+		{
+			name:  "unbind microgateway plan with prompts",
+			args:  []string{"apigee-unbind-mg", "--app", "myapp"},
+			stdin: "mg-svc\n",
+			want:  [][]string{{"unbind-route-service", "apps.example.com", "mg-svc", "--hostname", "myapp"}},
+		},
+		{
+			name: "bind picks the route given with --route",
+			args: []string{"apigee-bind-org", "--app", "multi", "--route", "https://api.example.com/v1/", "--service", "org-svc",
+				"--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy", "--bearer", "tok"},
+			want: [][]string{{"bind-route-service", "example.com", "org-svc", "--hostname", "api", "--path", "v1", "-c",
+				`{"org":"myorg","env":"test","action":"proxy","protocol":"","bearer":"tok"}`}},
+		},
+		{
+			name: "bind narrows routes down by domain",
+			args: []string{"apigee-bind-mg", "--app", "multi", "--domain", "apps.internal", "--service", "mg-svc",
+				"--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy", "--micro", "edgemicro.apps.internal", "--bearer", "tok"},
+			want: [][]string{{"bind-route-service", "apps.internal", "mg-svc", "--hostname", "multi", "-c",
+				`{"org":"myorg","env":"test","action":"proxy","protocol":"","micro":"edgemicro.apps.internal","bearer":"tok"}`}},
+			wantOut: "Using route multi.apps.internal of application multi",
+		},
+		{
+			name: "bind asks which of several routes to use",
+			args: []string{"apigee-bind-org", "--app", "multi", "--service", "org-svc",
+				"--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy", "--bearer", "tok"},
+			stdin: "\n\n2\n",
+			want: [][]string{{"bind-route-service", "apps.internal", "org-svc", "--hostname", "multi", "-c",
+				`{"org":"myorg","env":"test","action":"proxy","protocol":"","bearer":"tok"}`}},
+			wantOut: "  3. api.example.com/v1\nRoute to use [1-3]: ",
+		},
+		{
+			name: "bind needs --route non-interactively when there are several routes",
+			args: []string{"apigee-bind-org", "--app", "multi", "--service", "org-svc", "--non-interactive",
+				"--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy", "--bearer", "tok"},
+			wantCode: ExitMissingInput,
+			wantOut:  "missing required values for: --route",
+		},
+		{
+			name: "bind rejects routes the application does not have",
+			args: []string{"apigee-bind-org", "--app", "multi", "--route", "other.apps.example.com", "--service", "org-svc",
+				"--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy", "--bearer", "tok"},
+			wantCode: ExitUsage,
+			wantOut:  "its routes are: multi.apps.example.com, multi.apps.internal, api.example.com/v1",
+		},
+		{
+			name: "bind reports unknown applications",
+			args: []string{"apigee-bind-org", "--app", "missing", "--service", "org-svc",
+				"--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy", "--bearer", "tok"},
+			wantCode: ExitCfCommand,
+			wantOut:  "App missing not found",
+		},
+		{
+			name: "unbind org plan by route",
+			args: []string{"apigee-unbind-org", "--app", "multi", "--route", "api.example.com/v1", "--service", "org-svc"},
+			want: [][]string{{"unbind-route-service", "example.com", "org-svc", "--hostname", "api", "--path", "v1"}},
+		},
+		{
+			name: "unbind microgateway-coresident plan",
+			args: []string{"apigee-unbind-mgc", "--app", "myapp", "--service", "mgc-svc"},
+			want: [][]string{{"unbind-service", "myapp", "mgc-svc"}},
+		},
+		{
+			name:  "push without the coresident plan",
+			args:  []string{"apigee-push", "--app", "myapp"},
+			stdin: "n\n",
+			want:  [][]string{{"push", "myapp"}},
+		},
+		{
+			name: "push for the coresident plan without an archive",
+			args: []string{"apigee-push", "--app", "myapp", "--coresident", "--non-interactive"},
+			want: [][]string{{"push", "myapp", "--no-start"}},
+		},
+		{
+			name:     "push reports archive failures",
+			args:     []string{"apigee-push", "--coresident", "--archive", "does-not-exist.jar", "--config", "config", "--non-interactive"},
+			wantCode: ExitArchive,
+		},
+		{
+			name:     "push with a config directory implies the coresident plan",
+			args:     []string{"apigee-push", "--archive", "does-not-exist.jar", "--config", "config", "--non-interactive"},
+			wantCode: ExitArchive,
+			wantOut:  "as --config or --plugins was given",
+		},
+		{
+			name:     "push refuses an archive and a path without the coresident plan",
+			args:     []string{"apigee-push", "--app", "myapp", "--archive", "app.jar", "--path", "app", "--non-interactive"},
+			wantCode: ExitUsage,
+			wantOut:  "--archive and --path cannot be used together",
+		},
+		{
+			name:     "push fails non-interactively without a config directory",
+			args:     []string{"apigee-push", "--coresident", "--archive", "app.jar", "--non-interactive"},
+			wantCode: ExitMissingInput,
+			wantOut:  "--config",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := &fakeCliConnection{errs: test.errs}
+			out, code := runPlugin(conn, test.args, test.stdin, test.passwords...)
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d\noutput: %s", code, test.wantCode, out)
+			}
+			if !reflect.DeepEqual(conn.commands, test.want) {
+				t.Errorf("cf commands:\n got %q\nwant %q", conn.commands, test.want)
+			}
+			if !strings.Contains(out, test.wantOut) {
+				t.Errorf("output %q does not contain %q", out, test.wantOut)
+			}
+		})
+	}
+}
+
+func TestRunPushRewritesArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "app.jar")
+	writeTestZip(t, archive, map[string]string{"META-INF/MANIFEST.MF": "Manifest-Version: 1.0\n", "app/Main.class": "class"})
+	config := filepath.Join(dir, "config")
+	writeTestFiles(t, config, map[string]string{"myorg-test-config.yaml": "edge_config: {}\n"})
+
+	conn := &fakeCliConnection{}
+	out, code := runPlugin(conn, []string{"apigee-push", "--app", "myapp", "--archive", archive, "--config", config, "--coresident", "--non-interactive"}, "")
+	if code != 0 {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+	decorated := filepath.Join(dir, "apigee_app.jar")
+	want := [][]string{{"push", "myapp", "-p", decorated, "--no-start"}}
+	if !reflect.DeepEqual(conn.commands, want) {
+		t.Errorf("cf commands:\n got %q\nwant %q", conn.commands, want)
+	}
+
+	names := zipEntryNames(t, decorated)
+	for _, name := range []string{"META-INF/MANIFEST.MF", "app/Main.class", "config/myorg-test-config.yaml"} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("decorated archive is missing %s, has %v", name, names)
+		}
+	}
+}
+
+// writeTestZip writes files to a new archive in name order, adding directory entries ahead of the
+// files they contain the way the jar tool does
+func writeTestZip(t testing.TB, archive string, files map[string]string) {
+	target, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writer := zip.NewWriter(target)
+	dirs := make(map[string]bool)
+	for _, name := range names {
+		for i := 0; i < len(name); i++ {
+			if name[i] == '/' && !dirs[name[:i+1]] {
+				dirs[name[:i+1]] = true
+				if _, err := writer.Create(name[:i+1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestFiles(t testing.TB, dir string, files map[string]string) {
+	for name, contents := range files {
+		fpath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fpath, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func zipEntryNames(t *testing.T, archive string) map[string]struct{} {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	names := make(map[string]struct{})
+	for _, f := range r.File {
+		names[f.Name] = struct{}{}
+	}
+	return names
+}
+
+func userInput(value string, required bool) UserInput {
+	return UserInput{value: &value, requiredInput: required}
+}
+
+func TestCheckMissingListsEveryMissingFlag(t *testing.T) {
+	c := &ApigeeBrokerPlugin{nonInteractive: true}
+	generalConfig := map[string]UserInput{
+		"apigee_env": userInput("", true),
+		"apigee_org": userInput("org", true),
+		"protocol":   userInput("", false),
+		"service":    userInput("", true),
+	}
+	authConfig := map[string]UserInput{
+		"bearer": userInput("", false),
+		"basic":  userInput("", false),
+		"user":   userInput("someone", true),
+		"pass":   userInput("", true),
+	}
+
+	err := c.CheckMissing(generalConfig, []string{"apigee_env", "apigee_org", "protocol", "service"}, authConfig)
+	if err == nil {
+		t.Fatal("expected an error for missing values")
+	}
+	for _, want := range []string{"--pass (or --bearer)", "--apigee_env", "--service"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+	for _, unwanted := range []string{"--apigee_org", "--protocol", "--user"} {
+		if strings.Contains(err.Error(), unwanted) {
+			t.Errorf("error %q should not mention %s", err, unwanted)
+		}
+	}
+}
+
+func TestCheckMissingAcceptsBearer(t *testing.T) {
+	c := &ApigeeBrokerPlugin{nonInteractive: true}
+	generalConfig := map[string]UserInput{"service": userInput("svc", true)}
+	authConfig := map[string]UserInput{
+		"bearer": userInput("token", false),
+		"basic":  userInput("", false),
+		"user":   userInput("", true),
+		"pass":   userInput("", true),
+	}
+	if err := c.CheckMissing(generalConfig, []string{"service"}, authConfig); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+//AuthParams holds the Apigee credentials the service broker accepts. Only one of bearer, basic or user and pass is
+//expected to be set, the rest are left out of the JSON
+type AuthParams struct {
+	Bearer string `json:"bearer,omitempty"`
+	Basic  string `json:"basic,omitempty"`
+	User   string `json:"user,omitempty"`
+	Pass   string `json:"pass,omitempty"`
+}
+
+//OrgBindParams are the parameters sent to the service broker when binding a route with the org plan
+type OrgBindParams struct {
+	Org      string `json:"org"`
+	Env      string `json:"env"`
+	Action   string `json:"action"`
+	Protocol string `json:"protocol"`
+	Host     string `json:"host,omitempty"`
+	AuthParams
+}
+
+//MicroBindParams are the parameters sent to the service broker when binding a route with the microgateway plan
+type MicroBindParams struct {
+	Org      string `json:"org"`
+	Env      string `json:"env"`
+	Action   string `json:"action"`
+	Protocol string `json:"protocol"`
+	Micro    string `json:"micro"`
+	AuthParams
+}
+
+//CoresidentBindParams are the parameters sent to the service broker when binding an app with the
+//microgateway-coresident plan
+type CoresidentBindParams struct {
+	Org             string `json:"org"`
+	Env             string `json:"env"`
+	Action          string `json:"action"`
+	TargetAppRoute  string `json:"target_app_route"`
+	TargetAppPort   string `json:"target_app_port"`
+	EdgemicroKey    string `json:"edgemicro_key"`
+	EdgemicroSecret string `json:"edgemicro_secret"`
+	AuthParams
+}
+
+//NewAuthParams builds the credentials to send to the broker, preferring a bearer token, then pre-encoded basic
+//credentials, over username and password
+func NewAuthParams(bearer, basic, user, pass string) AuthParams {
+	if bearer != "" {
+		return AuthParams{Bearer: bearer}
+	}
+	if basic != "" {
+		return AuthParams{Basic: basic}
+	}
+	return AuthParams{User: user, Pass: pass}
+}
+
+//MarshalBindParams encodes a set of bind parameters into the JSON string passed to the cf cli with "-c". Characters
+//such as "<" and "&" are left as they are rather than escaped for HTML, so the JSON reads as typed
+func MarshalBindParams(params interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(params)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error encoding bind parameters: %s", err.Error())
+		return "", errors.New(errorMsg)
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+//writeParamsFile writes bind parameters to a temporary file only the current user can read, returning its path
+func (c *ApigeeBrokerPlugin) writeParamsFile(params string) (string, error) {
+	file, err := ioutil.TempFile("", "apigee-bind-params-")
+	if err != nil {
+		return "", newCommandErrorf(ExitFailure, "Error creating bind parameters file: %s", err.Error())
+	}
+	c.trackTemp(file.Name())
+	err = file.Chmod(0600)
+	if err == nil {
+		_, err = file.WriteString(params)
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		c.removeTemp(file.Name())
+		return "", newCommandErrorf(ExitFailure, "Error writing bind parameters file: %s", err.Error())
+	}
+	return file.Name(), nil
+}
+
+//CfBindCommand runs a cf command that takes bind parameters, passing them to "-c" as the path of a private temporary
+//file so that the credentials they hold do not show up in process listings. The file is removed once the command is
+//done. Dry runs show the parameters inline, with secrets hidden
+func (c *ApigeeBrokerPlugin) CfBindCommand(cliConnection plugin.CliConnection, params string, args ...string) error {
+	c.redactor.AddJSON(params)
+	if c.dryRun {
+		return c.CfCommand(cliConnection, append(args, "-c", params)...)
+	}
+	path, err := c.writeParamsFile(params)
+	if err != nil {
+		return err
+	}
+	defer c.removeTemp(path)
+	return c.CfCommand(cliConnection, append(args, "-c", path)...)
+}
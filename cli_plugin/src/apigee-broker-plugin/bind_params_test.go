@@ -0,0 +1,161 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var hostileValues = []string{
+	`plain`,
+	`with "quotes"`,
+	`back\slash\`,
+	`", "micro":"evil.example.com`,
+	`", "host":"evil.example.com", "x":"`,
+	"new\nline\ttab",
+	`</script><>&`,
+	"unicode \u2028 \u00e9 \U0001F600",
+}
+
+func decodeParams(t *testing.T, jsonString string) map[string]string {
+	decoded := map[string]string{}
+	if err := json.Unmarshal([]byte(jsonString), &decoded); err != nil {
+		t.Fatalf("bind parameters are not valid JSON: %v\n%s", err, jsonString)
+	}
+	return decoded
+}
+
+func TestMarshalBindParamsRoundTrip(t *testing.T) {
+	for _, value := range hostileValues {
+		tests := []struct {
+			name   string
+			params interface{}
+			want   map[string]string
+		}{
+			{
+				name: "org",
+				params: OrgBindParams{
+					Org: value, Env: value, Action: value, Protocol: value, Host: "host",
+					AuthParams: NewAuthParams("", "", value, value),
+				},
+				want: map[string]string{
+					"org": value, "env": value, "action": value, "protocol": value, "host": "host",
+					"user": value, "pass": value,
+				},
+			},
+			{
+				name: "microgateway",
+				params: MicroBindParams{
+					Org: "org", Env: "env", Action: "bind", Protocol: "https", Micro: value,
+					AuthParams: NewAuthParams("bearer"+value, "", "", ""),
+				},
+				want: map[string]string{
+					"org": "org", "env": "env", "action": "bind", "protocol": "https", "micro": value,
+					"bearer": "bearer" + value,
+				},
+			},
+			{
+				name: "microgateway-coresident",
+				params: CoresidentBindParams{
+					Org: "org", Env: "env", Action: "proxy bind", TargetAppRoute: value, TargetAppPort: value,
+					EdgemicroKey: value, EdgemicroSecret: value,
+					AuthParams: NewAuthParams("", value+"basic", "user", "pass"),
+				},
+				want: map[string]string{
+					"org": "org", "env": "env", "action": "proxy bind", "target_app_route": value, "target_app_port": value,
+					"edgemicro_key": value, "edgemicro_secret": value, "basic": value + "basic",
+				},
+			},
+		}
+
+		for _, test := range tests {
+			jsonString, err := MarshalBindParams(test.params)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", test.name, err)
+			}
+			got := decodeParams(t, jsonString)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s with %q:\n got %v\nwant %v", test.name, value, got, test.want)
+			}
+		}
+	}
+}
+
+func TestOrgBindParamsOmitsEmptyHost(t *testing.T) {
+	jsonString, err := MarshalBindParams(OrgBindParams{Org: "org", Env: "env", Action: "bind", AuthParams: NewAuthParams("token", "basic", "user", "pass")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"org":"org","env":"env","action":"bind","protocol":"","bearer":"token"}`
+	if jsonString != want {
+		t.Errorf("got %s, want %s", jsonString, want)
+	}
+}
+
+func TestBindParamsFile(t *testing.T) {
+	orgArgs := []string{"apigee-bind-org", "--service", "org-svc", "--app", "myapp", "--apigee_org", "myorg",
+		"--apigee_env", "test", "--action", "proxy", "--bearer", "tok", "--non-interactive"}
+	tests := []struct {
+		name     string
+		args     []string
+		errs     map[string]error
+		wantCode int
+	}{
+		{name: "route binding", args: orgArgs},
+		{name: "failed route binding", args: orgArgs, errs: map[string]error{"bind-route-service": errors.New("failed")}, wantCode: ExitCfCommand},
+		{name: "service binding", args: append(append([]string{}, coresidentArgs...), "--bearer", "tok", "--no-start")},
+		{name: "failed service binding", args: append(append([]string{}, coresidentArgs...), "--bearer", "tok", "--start"),
+			errs: map[string]error{"bind-service": errors.New("failed")}, wantCode: ExitCfCommand},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := &fakeCliConnection{errs: test.errs}
+			conn.onCommand = func(args []string) {
+				if args[len(args)-2] != "-c" {
+					return
+				}
+				path := args[len(args)-1]
+				if strings.HasPrefix(path, "{") {
+					t.Errorf("bind parameters passed on the command line: %s", path)
+					return
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Errorf("bind parameters file missing while binding: %v", err)
+				} else if info.Mode().Perm() != 0600 {
+					t.Errorf("bind parameters file has mode %v, want 0600", info.Mode().Perm())
+				}
+			}
+			out, code := runPlugin(conn, test.args, "")
+			if code != test.wantCode {
+				t.Fatalf("exit code %d, want %d\noutput: %s", code, test.wantCode, out)
+			}
+			if len(conn.paramsFiles) != 1 {
+				t.Fatalf("got %d bind parameters files, want 1", len(conn.paramsFiles))
+			}
+			if _, err := os.Stat(conn.paramsFiles[0]); !os.IsNotExist(err) {
+				t.Errorf("bind parameters file %s was not removed: %v", conn.paramsFiles[0], err)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// how long an interrupted command gets to stop writing to its temporary files before they are removed regardless
+const interruptGrace = 5 * time.Second
+
+//trackTemp records a temporary file or directory, or a partially written output, to remove if the command is
+//interrupted or fails before removing it
+func (c *ApigeeBrokerPlugin) trackTemp(path string) {
+	c.tempMutex.Lock()
+	defer c.tempMutex.Unlock()
+	c.temps = append(c.temps, path)
+}
+
+//untrackTemp forgets a path recorded with trackTemp without removing it, once the output is complete
+func (c *ApigeeBrokerPlugin) untrackTemp(path string) {
+	c.tempMutex.Lock()
+	defer c.tempMutex.Unlock()
+	for i, temp := range c.temps {
+		if temp == path {
+			c.temps = append(c.temps[:i], c.temps[i+1:]...)
+			break
+		}
+	}
+}
+
+//removeTemp removes a temporary file or directory recorded with trackTemp
+func (c *ApigeeBrokerPlugin) removeTemp(path string) {
+	c.untrackTemp(path)
+	os.RemoveAll(path)
+}
+
+//removeTemps removes every temporary file and directory that is still around
+func (c *ApigeeBrokerPlugin) removeTemps() {
+	c.tempMutex.Lock()
+	defer c.tempMutex.Unlock()
+	for _, temp := range c.temps {
+		os.RemoveAll(temp)
+	}
+	c.temps = nil
+}
+
+func (c *ApigeeBrokerPlugin) hasTemps() bool {
+	c.tempMutex.Lock()
+	defer c.tempMutex.Unlock()
+	return len(c.temps) > 0
+}
+
+//context returns the context of the running command, which is cancelled when the command is interrupted
+func (c *ApigeeBrokerPlugin) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+//handleInterrupts cancels the command when it is interrupted with Ctrl-C or terminated. A command writing temporary
+//files is given some time to stop and return, so that Run can remove them once nothing writes to them any more,
+//otherwise they are removed and the plugin exits straight away. The returned function stops handling signals once
+//the command has returned
+func (c *ApigeeBrokerPlugin) handleInterrupts(cancel context.CancelFunc) func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			fmt.Fprintln(c.Out, "\nInterrupted, cleaning up")
+			cancel()
+			if c.hasTemps() {
+				select {
+				case <-done:
+					return
+				case <-time.After(interruptGrace):
+				}
+			}
+			c.removeTemps()
+			c.Exit(ExitInterrupted)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+//contextReader stops a copy with the context's error once the context is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// buffers for copyContext, which cannot use the buffer-free shortcuts of io.Copy with its reader wrapped. Reusing them
+// matters when copying the thousands of entries of a large archive
+var copyBuffers = sync.Pool{New: func() interface{} {
+	buf := make([]byte, 32<<10)
+	return &buf
+}}
+
+//copyContext copies from src to dst like io.Copy, stopping early when ctx is cancelled
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(dst, contextReader{ctx: ctx, r: src}, *buf)
+}
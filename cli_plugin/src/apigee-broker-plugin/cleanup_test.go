@@ -0,0 +1,147 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInterruptDuringBind(t *testing.T) {
+	var out bytes.Buffer
+	code := ExitOK
+	c := newTestPlugin("", nil, &out, &code)
+
+	var paramsFile string
+	// cf is interrupted along with the plugin and fails
+	conn := &fakeCliConnection{errs: map[string]error{"bind-route-service": errors.New("cf was interrupted")}}
+	conn.onCommand = func(args []string) {
+		if args[0] != "bind-route-service" {
+			return
+		}
+		paramsFile = args[len(args)-1]
+		process, err := os.FindProcess(os.Getpid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := process.Signal(os.Interrupt); err != nil {
+			t.Skipf("cannot interrupt the test process: %v", err)
+		}
+		for deadline := time.Now().Add(5 * time.Second); c.context().Err() == nil; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("the interrupt was not handled")
+			}
+		}
+	}
+	c.Run(conn, []string{"apigee-bind-org", "--service", "org-svc", "--app", "myapp", "--apigee_org", "myorg",
+		"--apigee_env", "test", "--action", "proxy", "--bearer", "tok", "--non-interactive"})
+
+	if code != ExitInterrupted {
+		t.Errorf("exit code %d, want %d\noutput: %s", code, ExitInterrupted, out.String())
+	}
+	if !strings.Contains(out.String(), "Interrupted") || strings.Contains(out.String(), "cf was interrupted") {
+		t.Errorf("output %q should report the interrupt rather than the cf failure", out.String())
+	}
+	if paramsFile == "" {
+		t.Fatal("the bind command was not run")
+	}
+	if _, err := os.Stat(paramsFile); !os.IsNotExist(err) {
+		t.Errorf("bind parameters file %s was not removed on interrupt: %v", paramsFile, err)
+	}
+}
+
+// countdownContext reports itself cancelled once Err has been called n times, to interrupt a command at a chosen point
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestInterruptDuringPushLeavesNothingBehind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push_interrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "tmp")
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmp)
+
+	archive := filepath.Join(dir, "app.jar")
+	writeTestZip(t, archive, map[string]string{
+		"META-INF/MANIFEST.MF": "Manifest-Version: 1.0\n",
+		"app/Main.class":       strings.Repeat("class", 20000),
+		"app/Other.class":      "class",
+	})
+	config := filepath.Join(dir, "config")
+	writeTestFiles(t, config, map[string]string{"myorg-test-config.yaml": "edge_config: {}\n", "extra/plugin.js": "x"})
+	decorated := filepath.Join(dir, "apigee_app.jar")
+	args := []string{"apigee-push", "--app", "myapp", "--archive", archive, "--config", config, "--coresident", "--non-interactive"}
+
+	interrupted := 0
+	for n := 0; ; n++ {
+		if n > 1000 {
+			t.Fatal("push never completed")
+		}
+		var out bytes.Buffer
+		code := ExitOK
+		c := newTestPlugin("", nil, &out, &code)
+		c.ctx = &countdownContext{Context: context.Background(), n: n}
+		conn := &fakeCliConnection{}
+		err := c.ApigeePushCommand(conn, args)
+		if err == nil {
+			break
+		}
+		interrupted++
+		if len(conn.commands) > 0 {
+			t.Errorf("interrupted after %d checks but ran %q", n, conn.commands)
+		}
+		c.removeTemps()
+		if _, err := os.Stat(decorated); !os.IsNotExist(err) {
+			t.Errorf("interrupted after %d checks, left a partial %s", n, decorated)
+		}
+		if left, _ := ioutil.ReadDir(tmp); len(left) > 0 {
+			t.Fatalf("interrupted after %d checks, left %d temporary files", n, len(left))
+		}
+	}
+	if interrupted < 5 {
+		t.Errorf("push was only interrupted at %d points", interrupted)
+	}
+	if _, err := os.Stat(decorated); err != nil {
+		t.Errorf("completed push did not write %s: %v", decorated, err)
+	}
+	if left, _ := ioutil.ReadDir(tmp); len(left) > 0 {
+		t.Errorf("completed push left %d temporary files", len(left))
+	}
+}
@@ -18,6 +18,8 @@ package main
 
 import (
 	"archive/zip"
+	"compress/flate"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -26,150 +28,231 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
-//Extract takes in a destination folder that a desired archive, along with any other directories or files, will be extracted to
-func Extract(dest, archive, config, plugins string) error {
-	r, err := zip.OpenReader(archive)
-	if err != nil {
-		return err
-	}
-	defer r.Close()
+//ExtractLimits are the ceilings RewriteArchive enforces on an archive, guarding against archives crafted to fill the
+//disk once cf extracts them. A zero value turns a limit off
+type ExtractLimits struct {
+	// MaxTotalSize is the most bytes the archive may extract to
+	MaxTotalSize int64
+	// MaxEntries is the most files, directories and links the archive may contain
+	MaxEntries int
+	// MaxRatio is the most a file larger than ratioThreshold may be compressed, as uncompressed over compressed size
+	MaxRatio int64
+}
 
-	for _, f := range r.File {
-		fpath := filepath.Join(dest, f.Name)
-		if f.FileInfo().IsDir() {
+//DefaultExtractLimits are generous for application archives, which the cf cli limits to 1 GB
+var DefaultExtractLimits = ExtractLimits{
+	MaxTotalSize: 1 << 30,
+	MaxEntries:   100000,
+	MaxRatio:     200,
+}
 
-			err := os.MkdirAll(fpath, 0766)
-			if err != nil {
-				errorMsg := fmt.Sprintf("Error making directory \"%s\": %s", fpath, err.Error())
-				return errors.New(errorMsg)
-			}
-		} else {
+// files smaller than this are not held to MaxRatio, since small files of repeated text compress very well
+const ratioThreshold = 1 << 20
 
-			target, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-			if err != nil {
-				errorMsg := fmt.Sprintf("Error making new file at \"%s\": %s", fpath, err.Error())
-				return errors.New(errorMsg)
-			}
-			defer target.Close()
+//extractPath returns where an archive entry is extracted to under dest, rejecting absolute names and names that
+//would climb out of dest with ".."
+func extractPath(dest, name string) (string, error) {
+	if name == "" || path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" || strings.HasPrefix(name, `\`) {
+		return "", fmt.Errorf("Error: Archive entry \"%s\" has an absolute path", name)
+	}
+	fpath := filepath.Join(dest, filepath.FromSlash(name))
+	if !withinDir(dest, fpath) {
+		return "", fmt.Errorf("Error: Archive entry \"%s\" would be extracted outside of the archive's directory", name)
+	}
+	return fpath, nil
+}
 
-			rc, err := f.Open()
-			if err != nil {
-				errorMsg := fmt.Sprintf("Error reading in file from archive \"%s\": %s", f.Name, err.Error())
-				return errors.New(errorMsg)
-			}
-			defer rc.Close()
+//withinDir reports whether fpath is dir or a path inside it
+func withinDir(dir, fpath string) bool {
+	rel, err := filepath.Rel(dir, fpath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
 
-			_, err = io.Copy(target, rc)
-			if err != nil {
-				errorMsg := fmt.Sprintf("Error copying contents to new file \"%s\": %s", fpath, err.Error())
-				return errors.New(errorMsg)
-			}
+//checkLimits refuses an archive whose number of entries, or whose sizes as recorded in the archive, break limits
+func checkLimits(archive string, files []*zip.File, limits ExtractLimits) error {
+	if limits.MaxEntries > 0 && len(files) > limits.MaxEntries {
+		errorMsg := fmt.Sprintf("Error: Archive \"%s\" has %d entries, more than the limit of %d", archive, len(files), limits.MaxEntries)
+		return errors.New(errorMsg)
+	}
+	var declared uint64
+	for _, f := range files {
+		declared += f.UncompressedSize64
+		if limits.MaxRatio > 0 && f.UncompressedSize64 > ratioThreshold && f.UncompressedSize64 > f.CompressedSize64*uint64(limits.MaxRatio) {
+			errorMsg := fmt.Sprintf("Error: Archive entry \"%s\" is compressed more than the limit of %d to 1", f.Name, limits.MaxRatio)
+			return errors.New(errorMsg)
 		}
 	}
+	if limits.MaxTotalSize > 0 && declared > uint64(limits.MaxTotalSize) {
+		errorMsg := fmt.Sprintf("Error: Archive \"%s\" extracts to %d bytes, more than the limit of %d", archive, declared, limits.MaxTotalSize)
+		return errors.New(errorMsg)
+	}
+	return nil
+}
 
-	var pathSuffix string
-	pathSuffix = filepath.Base(config)
-	tmpPath := dest + string(os.PathSeparator) + pathSuffix
-	os.MkdirAll(tmpPath, 0766)
-	err = CopyDir(config, tmpPath)
+//checkSize decompresses an entry to count the bytes it really holds, which may be more than the archive records,
+//adding them to extracted. It refuses the entry as soon as it breaks the total size or compression ratio limits,
+//without reading further
+func checkSize(ctx context.Context, archive string, f *zip.File, limits ExtractLimits, extracted *int64) error {
+	if limits.MaxTotalSize <= 0 && limits.MaxRatio <= 0 {
+		return nil
+	}
+	raw, err := f.OpenRaw()
 	if err != nil {
-		return err
+		errorMsg := fmt.Sprintf("Error reading in file from archive \"%s\": %s", f.Name, err.Error())
+		return errors.New(errorMsg)
 	}
-
-	if len(plugins) > 0 {
-		pathSuffix = filepath.Base(plugins)
-		tmpPath = dest + string(os.PathSeparator) + pathSuffix
-		os.MkdirAll(tmpPath, 0766)
-		err = CopyDir(plugins, tmpPath)
+	// The raw contents are decompressed here, since zip.File.Open stops at the size the archive records
+	var contents io.Reader
+	switch f.Method {
+	case zip.Store:
+		contents = raw
+	case zip.Deflate:
+		decompressor := flate.NewReader(raw)
+		defer decompressor.Close()
+		contents = decompressor
+	default:
+		rc, err := f.Open()
 		if err != nil {
-			return err
+			errorMsg := fmt.Sprintf("Error reading in file from archive \"%s\": %s", f.Name, err.Error())
+			return errors.New(errorMsg)
 		}
+		defer rc.Close()
+		contents = rc
 	}
 
+	// Read one byte past each limit to tell an entry that reaches it from one that goes over
+	limit := int64(-1)
+	if limits.MaxTotalSize > 0 {
+		limit = limits.MaxTotalSize - *extracted + 1
+	}
+	if limits.MaxRatio > 0 {
+		ratioLimit := int64(f.CompressedSize64)*limits.MaxRatio + 1
+		if ratioLimit < ratioThreshold+1 {
+			ratioLimit = ratioThreshold + 1
+		}
+		if limit < 0 || ratioLimit < limit {
+			limit = ratioLimit
+		}
+	}
+	n, err := copyContext(ctx, ioutil.Discard, io.LimitReader(contents, limit))
+	*extracted += n
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		errorMsg := fmt.Sprintf("Error reading in file from archive \"%s\": %s", f.Name, err.Error())
+		return errors.New(errorMsg)
+	}
+	if limits.MaxTotalSize > 0 && *extracted > limits.MaxTotalSize {
+		errorMsg := fmt.Sprintf("Error: Archive \"%s\" extracts to more than the limit of %d bytes", archive, limits.MaxTotalSize)
+		return errors.New(errorMsg)
+	}
+	if n == limit {
+		errorMsg := fmt.Sprintf("Error: Archive entry \"%s\" is compressed more than the limit of %d to 1", f.Name, limits.MaxRatio)
+		return errors.New(errorMsg)
+	}
 	return nil
 }
 
-//Compress takes in a source directory and compresses its contents into a target archive
-func Compress(source string, dest string) (string, error) {
-	target, err := os.Create(dest)
+//ArchiveAdditions lists the entries RewriteArchive would add to an archive with options, without writing anything.
+//Entries that would replace one already in the archive are marked. It also returns what would be left out, and stops
+//with ctx's error once ctx is cancelled
+func ArchiveAdditions(ctx context.Context, archive string, options RewriteOptions) ([]string, SkipSummary, error) {
+	r, err := zip.OpenReader(archive)
 	if err != nil {
-		errorMsg := fmt.Sprintf("Error making new archive \"%s\": %s", dest, err.Error())
-		return "", errors.New(errorMsg)
+		return nil, SkipSummary{}, err
 	}
-	defer target.Close()
+	defer r.Close()
 
-	archiveWriter := zip.NewWriter(target)
-	defer archiveWriter.Close()
+	existing := make(map[string]bool)
+	for _, f := range r.File {
+		existing[f.Name] = true
+	}
 
-	walkfn := func(fpath string, info os.FileInfo, err error) error {
-		if err != nil {
-			errorMsg := fmt.Sprintf("Error walking file path: %s", err.Error())
-			return errors.New(errorMsg)
+	entries, skipped, err := archiveDirEntries(ctx, options.Dirs, options.Symlinks, options.Exclude)
+	if err != nil {
+		return nil, skipped, err
+	}
+	additions := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := options.Prefix + entry.zipName()
+		if existing[name] && !entry.info.IsDir() {
+			name += " (replaces the existing entry)"
 		}
+		additions = append(additions, name)
+	}
+	return additions, skipped, nil
+}
 
-		fileHeader, err := zip.FileInfoHeader(info)
-		if err != nil {
-			errorMsg := fmt.Sprintf("Error getting file header: %s", err.Error())
-			return errors.New(errorMsg)
+//addEntry adds the file, directory or symbolic link at fpath to an archive under name, compressing it unless it is
+//already compressed. fixHeader, when not nil, may change the entry's metadata
+func addEntry(ctx context.Context, archiveWriter *zip.Writer, name, fpath string, info os.FileInfo, fixHeader func(*zip.FileHeader)) error {
+	fileHeader, err := zip.FileInfoHeader(info)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error getting file header: %s", err.Error())
+		return errors.New(errorMsg)
+	}
+	fileHeader.Name = name
+
+	if info.IsDir() {
+		fileHeader.Name += "/"
+	} else {
+		//no need to compress what's already compressed
+		ext := strings.ToLower(path.Ext(fileHeader.Name))
+		if _, ok := compressedFormats[ext]; ok {
+			fileHeader.Method = zip.Store
+		} else {
+			fileHeader.Method = zip.Deflate
 		}
+	}
+	if fixHeader != nil {
+		fixHeader(fileHeader)
+	}
 
-		name, err := filepath.Rel(source, fpath)
-		if err != nil {
-			return err
-		}
-		fileHeader.Name = filepath.ToSlash(name)
+	writer, err := archiveWriter.CreateHeader(fileHeader)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error adding filemetadata to archive: %s", err.Error())
+		return errors.New(errorMsg)
+	}
 
-		if info.IsDir() {
-			fileHeader.Name += "/"
-		} else {
-			//no need to compress what's already compressed
-			ext := strings.ToLower(path.Ext(fileHeader.Name))
-			if _, ok := compressedFormats[ext]; ok {
-				fileHeader.Method = zip.Store
-			} else {
-				fileHeader.Method = zip.Deflate
-			}
-		}
+	if info.IsDir() {
+		return nil
+	}
 
-		writer, err := archiveWriter.CreateHeader(fileHeader)
+	// Zip archives store the target of a symbolic link as its contents
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(fpath)
 		if err != nil {
-			errorMsg := fmt.Sprintf("Error adding filemetadata to archive: %s", err.Error())
+			errorMsg := fmt.Sprintf("Error reading symbolic link \"%s\": %s", fpath, err.Error())
 			return errors.New(errorMsg)
 		}
+		_, err = writer.Write([]byte(filepath.ToSlash(link)))
+		return err
+	}
 
-		if info.IsDir() {
-			return nil
+	if fileHeader.Mode().IsRegular() {
+		file, err := os.Open(fpath)
+		if err != nil {
+			errorMsg := fmt.Sprintf("Error reading in file \"%s\": %s", fpath, err.Error())
+			return errors.New(errorMsg)
 		}
+		defer file.Close()
 
-		if fileHeader.Mode().IsRegular() {
-			file, err := os.Open(fpath)
-			if err != nil {
-				errorMsg := fmt.Sprintf("Error reading in file \"%s\": %s", fpath, err.Error())
-				return errors.New(errorMsg)
-			}
-			defer file.Close()
-
-			_, err = io.Copy(writer, file)
-			if err != nil {
-				errorMsg := fmt.Sprintf("Error copying contents to new file \"%s\" in archive: %s", fileHeader.Name, err.Error())
-				return errors.New(errorMsg)
-			}
+		_, err = copyContext(ctx, writer, file)
+		if err != nil {
+			errorMsg := fmt.Sprintf("Error copying contents to new file \"%s\" in archive: %s", fileHeader.Name, err.Error())
+			return errors.New(errorMsg)
 		}
-		return nil
-	}
-
-	err = filepath.Walk(source, walkfn)
-	if err != nil {
-		return "", err
 	}
-
-	return dest, nil
+	return nil
 }
 
-//CopyFile takes in a source and destination string and copies a file at source to the destinaton
-func CopyFile(source string, dest string) error {
+//CopyFile takes in a source and destination string and copies a file at source to the destinaton, keeping its mode
+//and modification time
+func CopyFile(ctx context.Context, source string, dest string) error {
 	sourceFile, err := os.Open(source)
 	if err != nil {
 		errorMsg := fmt.Sprintf("Error reading in file \"%s\": %s", source, err.Error())
@@ -183,60 +266,102 @@ func CopyFile(source string, dest string) error {
 		return errors.New(errorMsg)
 	}
 
-	destFile, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, sourceInfo.Mode())
+	// Replace rather than write through whatever is at dest, which may be read-only or a link
+	os.Remove(dest)
+	destFile, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, sourceInfo.Mode().Perm())
 	if err != nil {
 		errorMsg := fmt.Sprintf("Error making new file at \"%s\": %s", dest, err.Error())
 		return errors.New(errorMsg)
 	}
 	defer destFile.Close()
 
-	_, err = io.Copy(destFile, sourceFile)
+	_, err = copyContext(ctx, destFile, sourceFile)
 	if err != nil {
 		errorMsg := fmt.Sprintf("Error copying contents to new file \"%s\" in archive: %s", dest, err.Error())
 		return errors.New(errorMsg)
 	}
-	return nil
-}
-
-//CopyDir takes in a source and destination string an copies a directory and its contents to the destination
-func CopyDir(source string, dest string) error {
-	sourceInfo, err := os.Stat(source)
-	if err != nil {
-		errorMsg := fmt.Sprintf("Error reading in directory \"%s\" info: %s", source, err.Error())
-		return errors.New(errorMsg)
-	}
-
-	if !sourceInfo.IsDir() {
-		errorMsg := "Specified file is not a directory"
-		return errors.New(errorMsg)
+	err = destFile.Close()
+	if err == nil {
+		err = setModeAndTime(dest, sourceInfo.Mode(), sourceInfo.ModTime())
 	}
-
-	err = os.MkdirAll(dest, sourceInfo.Mode())
 	if err != nil {
-		errorMsg := fmt.Sprintf("Error making directory \"%s\": %s", dest, err.Error())
+		errorMsg := fmt.Sprintf("Error writing new file \"%s\": %s", dest, err.Error())
 		return errors.New(errorMsg)
 	}
+	return nil
+}
 
-	files, err := ioutil.ReadDir(source)
-	if err != nil {
-		errorMsg := fmt.Sprintf("Error reading in directory: %s", err.Error())
-		return errors.New(errorMsg)
+//setModeAndTime gives fpath mode's permissions, which the umask may have taken away when it was made, and modTime
+func setModeAndTime(fpath string, mode os.FileMode, modTime time.Time) error {
+	err := os.Chmod(fpath, mode.Perm())
+	if err == nil && !modTime.IsZero() {
+		err = os.Chtimes(fpath, modTime, modTime)
 	}
+	return err
+}
+
+//CopyDir takes in a source and destination string an copies a directory and its contents to the destination, applying
+//policy to the symbolic links in it. Modes and modification times are kept
+func CopyDir(ctx context.Context, source string, dest string, policy SymlinkPolicy) error {
+	return copyDir(ctx, source, dest, policy, nil)
+}
 
-	for _, file := range files {
-		spath := source + string(os.PathSeparator) + file.Name()
-		dpath := dest + string(os.PathSeparator) + file.Name()
+//copiedDir is a directory whose mode and modification time are set once its contents are copied
+type copiedDir struct {
+	path    string
+	mode    os.FileMode
+	modTime time.Time
+}
 
-		if file.IsDir() {
-			err := CopyDir(spath, dpath)
+//copyDir copies source to dest like CopyDir, leaving out the files and directories skip returns true for
+func copyDir(ctx context.Context, source, dest string, policy SymlinkPolicy, skip func(name, fpath string, info os.FileInfo) bool) error {
+	dirs := make([]copiedDir, 0)
+	err := walkDir(ctx, source, policy, func(name, fpath string, info os.FileInfo) error {
+		if name != "." && skip != nil && skip(name, fpath, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dest, name)
+		switch {
+		case info.IsDir():
+			// Kept writable until its contents are copied, whatever its mode
+			err := os.MkdirAll(target, 0700)
 			if err != nil {
-				return err
+				errorMsg := fmt.Sprintf("Error making directory \"%s\": %s", target, err.Error())
+				return errors.New(errorMsg)
+			}
+			dirs = append(dirs, copiedDir{path: target, mode: info.Mode(), modTime: info.ModTime()})
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(fpath)
+			if err == nil {
+				os.Remove(target)
+				err = os.Symlink(link, target)
 			}
-		} else {
-			err := CopyFile(spath, dpath)
 			if err != nil {
-				return err
+				errorMsg := fmt.Sprintf("Error copying symbolic link \"%s\": %s", fpath, err.Error())
+				return errors.New(errorMsg)
 			}
+		default:
+			return CopyFile(ctx, fpath, target)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return finishDirs(dirs)
+}
+
+//finishDirs sets the modes and modification times of directories, deepest first, since adding to a directory changes
+//its modification time and a read-only directory cannot be added to
+func finishDirs(dirs []copiedDir) error {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		err := setModeAndTime(dirs[i].path, dirs[i].mode, dirs[i].modTime)
+		if err != nil {
+			errorMsg := fmt.Sprintf("Error setting mode of directory \"%s\": %s", dirs[i].path, err.Error())
+			return errors.New(errorMsg)
 		}
 	}
 	return nil
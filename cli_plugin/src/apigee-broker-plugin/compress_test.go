@@ -0,0 +1,137 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// craftedEntry is an archive entry written exactly as given, however malicious
+type craftedEntry struct {
+	name   string
+	body   string
+	link   bool
+	stored bool
+}
+
+func writeCraftedZip(t *testing.T, archive string, entries []craftedEntry) {
+	target, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	w := zip.NewWriter(target)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		if entry.stored {
+			header.Method = zip.Store
+		}
+		if entry.link {
+			header.SetMode(os.ModeSymlink | 0777)
+		} else {
+			header.SetMode(0644)
+		}
+		writer, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeLyingZip writes an archive whose single entry claims to be much smaller than it is
+func writeLyingZip(t *testing.T, archive string, name string, body []byte) {
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(body)
+	fw.Close()
+
+	target, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	w := zip.NewWriter(target)
+	header := &zip.FileHeader{
+		Name:               name,
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(body),
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: 10,
+	}
+	writer, err := w.CreateRaw(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(compressed.Bytes())
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPushExtractLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push_limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "app.jar")
+	writeCraftedZip(t, archive, []craftedEntry{{name: "a", body: "a"}, {name: "b", body: "b"}, {name: "c", body: "c"}})
+	config := filepath.Join(dir, "config")
+	writeTestFiles(t, config, map[string]string{"myorg-test-config.yaml": "edge_config: {}\n"})
+	args := []string{"apigee-push", "--app", "myapp", "--archive", archive, "--config", config, "--coresident", "--non-interactive"}
+	lying := filepath.Join(dir, "lying.jar")
+	writeLyingZip(t, lying, "zeros", bytes.Repeat([]byte("\x00"), 4<<20))
+	lyingArgs := []string{"apigee-push", "--app", "myapp", "--archive", lying, "--config", config, "--coresident", "--non-interactive",
+		"--max-ratio", "0", "--max-extracted-size", "1"}
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantOut  string
+	}{
+		{name: "over the limit", args: append(args, "--max-entries", "2"), wantCode: ExitArchive, wantOut: "has 3 entries, more than the limit of 2"},
+		{name: "limit turned off", args: append(args, "--max-entries", "0")},
+		{name: "size recorded in the archive is a lie", args: lyingArgs, wantCode: ExitArchive, wantOut: "extracts to more than the limit of 1048576 bytes"},
+		{name: "negative limit", args: append(args, "--max-ratio", "-1"), wantCode: ExitUsage, wantOut: "cannot be negative"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, code := runPlugin(&fakeCliConnection{}, test.args, "")
+			if code != test.wantCode || !strings.Contains(out, test.wantOut) {
+				t.Errorf("exit code %d, want %d\noutput %q does not contain %q", code, test.wantCode, out, test.wantOut)
+			}
+		})
+	}
+}
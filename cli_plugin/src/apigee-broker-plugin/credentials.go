@@ -0,0 +1,259 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//DefaultManagementHost is the Apigee Edge management API host looked up in ~/.netrc
+const DefaultManagementHost = "api.enterprise.apigee.com"
+
+//CredentialSources are the flags naming where Apigee credentials can be read from, other than the credentials
+//themselves
+type CredentialSources struct {
+	BearerFile  *string
+	PassFile    *string
+	BearerStdin *bool
+	MgmtHost    *string
+}
+
+//AddCredentialFlags defines the credential source flags shared by the bind commands
+func AddCredentialFlags(flags *flag.FlagSet) CredentialSources {
+	return CredentialSources{
+		BearerFile:  flags.String("bearer-file", "", "File containing the Apigee bearer token"),
+		PassFile:    flags.String("pass-file", "", "File containing the Apigee password"),
+		BearerStdin: flags.Bool("bearer-stdin", false, "Read the Apigee bearer token from stdin"),
+		MgmtHost:    flags.String("mgmt_host", DefaultManagementHost, "Apigee management API host to look up in ~/.netrc"),
+	}
+}
+
+//ResolveCredentials fills in Apigee credentials that were not given as flags. Sources are tried in order: flags,
+//--bearer-file and --pass-file, --bearer-stdin, the APIGEE_USER, APIGEE_PASSWORD, APIGEE_BEARER and APIGEE_BASIC
+//environment variables, a ~/.netrc entry for the management host and finally the token cached by apigee-login.
+//The first complete set of credentials wins, and the sources used are reported without the secrets themselves
+func (c *ApigeeBrokerPlugin) ResolveCredentials(authConfig map[string]UserInput, sources CredentialSources) error {
+	origins := make(map[string]string)
+	for _, key := range []string{"bearer", "basic", "user", "pass"} {
+		if *authConfig[key].value != "" {
+			origins[key] = "--" + key
+		}
+	}
+	value := func(key string) string {
+		return *authConfig[key].value
+	}
+	complete := func() bool {
+		return value("bearer") != "" || value("basic") != "" || (value("user") != "" && value("pass") != "")
+	}
+	set := func(key, newValue, origin string) {
+		if newValue == "" || value(key) != "" {
+			return
+		}
+		// Once a username or password has been given, only complete that pair
+		if (key == "bearer" || key == "basic") && (value("user") != "" || value("pass") != "") {
+			return
+		}
+		*authConfig[key].value = newValue
+		origins[key] = origin
+	}
+
+	steps := []func() error{
+		func() error {
+			for _, source := range [][2]string{{"bearer", *sources.BearerFile}, {"pass", *sources.PassFile}} {
+				key, path := source[0], source[1]
+				if path == "" || value(key) != "" {
+					continue
+				}
+				secret, err := readSecretFile(path)
+				if err != nil {
+					return err
+				}
+				set(key, secret, "--"+key+"-file")
+			}
+			return nil
+		},
+		func() error {
+			if !*sources.BearerStdin || value("bearer") != "" {
+				return nil
+			}
+			data, err := ioutil.ReadAll(c.input())
+			if err != nil {
+				return newCommandErrorf(ExitAuth, "Error reading bearer token from stdin: %s", err.Error())
+			}
+			set("bearer", strings.TrimSpace(string(data)), "stdin")
+			return nil
+		},
+		func() error {
+			for _, source := range [][2]string{{"bearer", "APIGEE_BEARER"}, {"basic", "APIGEE_BASIC"}, {"user", "APIGEE_USER"}, {"pass", "APIGEE_PASSWORD"}} {
+				set(source[0], c.getenv(source[1]), source[1])
+			}
+			return nil
+		},
+		func() error {
+			login, password, err := c.netrcCredentials(*sources.MgmtHost)
+			if err != nil {
+				return err
+			}
+			if value("user") == "" || value("user") == login {
+				set("user", login, "~/.netrc")
+				set("pass", password, "~/.netrc")
+			}
+			return nil
+		},
+		func() error {
+			if value("user") != "" || value("pass") != "" {
+				return nil
+			}
+			bearer, err := c.CachedBearer()
+			if err != nil {
+				return err
+			}
+			set("bearer", bearer, "cf apigee-login")
+			return nil
+		},
+	}
+
+	for _, step := range steps {
+		if complete() {
+			break
+		}
+		err := step()
+		if err != nil {
+			return err
+		}
+	}
+
+	c.redactor.Add(value("bearer"), value("basic"), value("pass"))
+	switch {
+	case value("bearer") != "":
+		fmt.Fprintf(c.Out, "Authenticating with Apigee using the bearer token from %s\n", origins["bearer"])
+	case value("basic") != "":
+		fmt.Fprintf(c.Out, "Authenticating with Apigee using the basic credentials from %s\n", origins["basic"])
+	case complete():
+		fmt.Fprintf(c.Out, "Authenticating with Apigee using the username from %s and the password from %s\n", origins["user"], origins["pass"])
+	}
+	return nil
+}
+
+//getenv looks up an environment variable through the plugin so that tests do not depend on the real environment
+func (c *ApigeeBrokerPlugin) getenv(key string) string {
+	if c.Getenv == nil {
+		return os.Getenv(key)
+	}
+	return c.Getenv(key)
+}
+
+//readSecretFile reads a credential from a file, dropping the trailing newline editors add
+func readSecretFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", newCommandErrorf(ExitAuth, "Error reading credentials file \"%s\": %s", path, err.Error())
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+//netrcCredentials returns the login and password for host from the user's netrc file, honouring the NETRC
+//environment variable. Missing files and hosts are not errors
+func (c *ApigeeBrokerPlugin) netrcCredentials(host string) (string, string, error) {
+	path := c.getenv("NETRC")
+	if path == "" {
+		home := c.getenv("HOME")
+		if home == "" {
+			home = c.getenv("USERPROFILE")
+		}
+		if home == "" {
+			return "", "", nil
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", newCommandErrorf(ExitAuth, "Error reading \"%s\": %s", path, err.Error())
+	}
+	login, password := ParseNetrc(string(data), host)
+	return login, password, nil
+}
+
+//ParseNetrc returns the login and password netrc contents give for host, falling back to a default entry
+func ParseNetrc(contents, host string) (string, string) {
+	var login, password, defaultLogin, defaultPassword string
+	var inMachine, inDefault, found bool
+
+	lines := strings.Split(contents, "\n")
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		for j := 0; j < len(fields); j++ {
+			next := func() string {
+				if j+1 < len(fields) {
+					j++
+					return fields[j]
+				}
+				return ""
+			}
+			switch fields[j] {
+			case "machine":
+				if found {
+					return login, password
+				}
+				inMachine = next() == host
+				inDefault = false
+			case "default":
+				if found {
+					return login, password
+				}
+				inMachine = false
+				inDefault = true
+			case "login":
+				value := next()
+				if inMachine {
+					login, found = value, true
+				} else if inDefault {
+					defaultLogin = value
+				}
+			case "password":
+				value := next()
+				if inMachine {
+					password, found = value, true
+				} else if inDefault {
+					defaultPassword = value
+				}
+			case "macdef":
+				// Macro definitions run until the next blank line
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				j = len(fields)
+			default:
+				if strings.HasPrefix(fields[j], "#") {
+					j = len(fields)
+				}
+			}
+		}
+	}
+	if found {
+		return login, password
+	}
+	return defaultLogin, defaultPassword
+}
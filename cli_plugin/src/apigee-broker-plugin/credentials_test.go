@@ -0,0 +1,226 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNetrc(t *testing.T) {
+	contents := `# credentials for Apigee
+machine example.com login other password nope
+machine api.enterprise.apigee.com
+	login me@example.com
+	password "s3cret#1"
+
+macdef init
+	machine api.enterprise.apigee.com login macro password macro
+
+default login anonymous password guest
+`
+	tests := []struct {
+		host, wantLogin, wantPassword string
+	}{
+		{"api.enterprise.apigee.com", "me@example.com", `"s3cret#1"`},
+		{"example.com", "other", "nope"},
+		{"onprem.example.com", "anonymous", "guest"},
+	}
+	for _, test := range tests {
+		login, password := ParseNetrc(contents, test.host)
+		if login != test.wantLogin || password != test.wantPassword {
+			t.Errorf("ParseNetrc(%s) = %q, %q, want %q, %q", test.host, login, password, test.wantLogin, test.wantPassword)
+		}
+	}
+}
+
+func TestResolveCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFiles(t, dir, map[string]string{
+		"token": "file-token\n",
+		"pass":  "file-pass\r\n",
+		"netrc": "machine api.enterprise.apigee.com login netrc-user password netrc-pass\nmachine onprem.example.com login onprem password onprem-pass\n",
+	})
+	home := filepath.Join(dir, "home")
+	cache := &ApigeeBrokerPlugin{CfHome: home}
+	if err := cache.SaveTokenCache(&TokenCache{AccessToken: "cached-token", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	bind := []string{"apigee-bind-org", "--app", "myapp", "--domain", "apps.example.com", "--service", "org-svc",
+		"--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy", "--non-interactive"}
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		stdin      string
+		home       string
+		wantAuth   string
+		wantSource string
+		wantCode   int
+	}{
+		{
+			name:       "flag wins over the environment",
+			args:       []string{"--bearer", "flag-token"},
+			env:        map[string]string{"APIGEE_BEARER": "env-token", "APIGEE_USER": "env-user", "APIGEE_PASSWORD": "env-pass"},
+			wantAuth:   `"bearer":"flag-token"`,
+			wantSource: "bearer token from --bearer",
+		},
+		{
+			name:       "environment bearer",
+			env:        map[string]string{"APIGEE_BEARER": "env-token"},
+			wantAuth:   `"bearer":"env-token"`,
+			wantSource: "bearer token from APIGEE_BEARER",
+		},
+		{
+			name:       "bearer file wins over the environment",
+			args:       []string{"--bearer-file", filepath.Join(dir, "token")},
+			env:        map[string]string{"APIGEE_BEARER": "env-token", "APIGEE_USER": "env-user", "APIGEE_PASSWORD": "env-pass"},
+			wantAuth:   `"bearer":"file-token"`,
+			wantSource: "bearer token from --bearer-file",
+		},
+		{
+			name:       "bearer from stdin wins over the environment",
+			args:       []string{"--bearer-stdin"},
+			env:        map[string]string{"APIGEE_USER": "env-user"},
+			stdin:      "stdin-token\n",
+			wantAuth:   `"bearer":"stdin-token"`,
+			wantSource: "bearer token from stdin",
+		},
+		{
+			name:       "password file completed by environment username",
+			args:       []string{"--pass-file", filepath.Join(dir, "pass")},
+			env:        map[string]string{"APIGEE_USER": "env-user", "APIGEE_PASSWORD": "env-pass"},
+			wantAuth:   `"user":"env-user","pass":"file-pass"`,
+			wantSource: "username from APIGEE_USER and the password from --pass-file",
+		},
+		{
+			name:       "user flag completed by environment password",
+			args:       []string{"--user", "flag-user"},
+			env:        map[string]string{"APIGEE_BEARER": "env-token", "APIGEE_PASSWORD": "env-pass"},
+			wantAuth:   `"user":"flag-user","pass":"env-pass"`,
+			wantSource: "username from --user and the password from APIGEE_PASSWORD",
+		},
+		{
+			name:       "environment basic credentials",
+			env:        map[string]string{"APIGEE_BASIC": "dXNlcjpwYXNz"},
+			wantAuth:   `"basic":"dXNlcjpwYXNz"`,
+			wantSource: "basic credentials from APIGEE_BASIC",
+		},
+		{
+			name:       "basic flag",
+			args:       []string{"--basic", "dXNlcjpwYXNz"},
+			wantAuth:   `"basic":"dXNlcjpwYXNz"`,
+			wantSource: "basic credentials from --basic",
+		},
+		{
+			name:       "bearer file",
+			args:       []string{"--bearer-file", filepath.Join(dir, "token")},
+			wantAuth:   `"bearer":"file-token"`,
+			wantSource: "bearer token from --bearer-file",
+		},
+		{
+			name:       "password file",
+			args:       []string{"--user", "me", "--pass-file", filepath.Join(dir, "pass")},
+			wantAuth:   `"user":"me","pass":"file-pass"`,
+			wantSource: "password from --pass-file",
+		},
+		{
+			name:     "unreadable password file",
+			args:     []string{"--user", "me", "--pass-file", filepath.Join(dir, "missing")},
+			wantCode: ExitAuth,
+		},
+		{
+			name:       "bearer from stdin",
+			args:       []string{"--bearer-stdin"},
+			stdin:      "stdin-token\n",
+			wantAuth:   `"bearer":"stdin-token"`,
+			wantSource: "bearer token from stdin",
+		},
+		{
+			name:       "netrc entry for the management host",
+			env:        map[string]string{"NETRC": filepath.Join(dir, "netrc")},
+			wantAuth:   `"user":"netrc-user","pass":"netrc-pass"`,
+			wantSource: "username from ~/.netrc and the password from ~/.netrc",
+		},
+		{
+			name:       "netrc entry for another management host",
+			args:       []string{"--mgmt_host", "onprem.example.com"},
+			env:        map[string]string{"NETRC": filepath.Join(dir, "netrc")},
+			wantAuth:   `"user":"onprem","pass":"onprem-pass"`,
+			wantSource: "username from ~/.netrc",
+		},
+		{
+			name:       "netrc wins over the apigee-login cache",
+			env:        map[string]string{"NETRC": filepath.Join(dir, "netrc")},
+			home:       home,
+			wantAuth:   `"user":"netrc-user","pass":"netrc-pass"`,
+			wantSource: "~/.netrc",
+		},
+		{
+			name:       "apigee-login cache",
+			home:       home,
+			wantAuth:   `"bearer":"cached-token"`,
+			wantSource: "bearer token from cf apigee-login",
+		},
+		{
+			name:     "nothing available",
+			env:      map[string]string{"HOME": filepath.Join(dir, "nohome")},
+			wantCode: ExitMissingInput,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			code := ExitOK
+			c := newTestPlugin(test.stdin, nil, &out, &code)
+			c.CfHome = test.home
+			c.Getenv = func(key string) string { return test.env[key] }
+			conn := &fakeCliConnection{}
+			c.Run(conn, append(append([]string{}, bind...), test.args...))
+
+			if code != test.wantCode {
+				t.Fatalf("exit code %d, want %d\noutput: %s", code, test.wantCode, out.String())
+			}
+			if test.wantCode != ExitOK {
+				return
+			}
+			if !strings.Contains(conn.commands[0][6], test.wantAuth) {
+				t.Errorf("bind parameters %s do not contain %s", conn.commands[0][6], test.wantAuth)
+			}
+			if !strings.Contains(out.String(), test.wantSource) {
+				t.Errorf("output %q does not report %q", out.String(), test.wantSource)
+			}
+			secrets := []string{"flag-token", "env-token", "env-pass", "dXNlcjpwYXNz", "file-token", "file-pass", "stdin-token", "netrc-pass", "onprem-pass", "cached-token"}
+			for _, secret := range secrets {
+				if strings.Contains(out.String(), secret) {
+					t.Errorf("output %q reveals the secret %q", out.String(), secret)
+				}
+			}
+		})
+	}
+}
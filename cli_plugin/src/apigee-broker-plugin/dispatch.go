@@ -0,0 +1,140 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+)
+
+//deprecatedCommands maps the plan specific bind and unbind commands to the command replacing them
+var deprecatedCommands = map[string]string{
+	"apigee-bind-org":   "apigee-bind",
+	"apigee-bind-mg":    "apigee-bind",
+	"apigee-bind-mgc":   "apigee-bind",
+	"apigee-unbind-org": "apigee-unbind",
+	"apigee-unbind-mg":  "apigee-unbind",
+	"apigee-unbind-mgc": "apigee-unbind",
+}
+
+//ApigeeBindCommand binds an application to a service instance of any Apigee plan, handing off to the command for
+//the instance's plan
+func (c *ApigeeBrokerPlugin) ApigeeBindCommand(cliConnection plugin.CliConnection, args []string) error {
+	instance, plan, args, err := c.ResolvePlan(cliConnection, args, true)
+	if err != nil || plan == "" {
+		return err
+	}
+	if plan == PlanCoresident {
+		return c.ApigeeBindServiceCommand(cliConnection, args, instance)
+	}
+	return c.ApigeeBindRouteCommand(cliConnection, args, plan, instance)
+}
+
+//ApigeeUnbindCommand unbinds an application from a service instance of any Apigee plan
+func (c *ApigeeBrokerPlugin) ApigeeUnbindCommand(cliConnection plugin.CliConnection, args []string) error {
+	instance, plan, args, err := c.ResolvePlan(cliConnection, args, false)
+	if err != nil || plan == "" {
+		return err
+	}
+	return c.ApigeeUnbindPlanCommand(cliConnection, args, plan, instance)
+}
+
+//ResolvePlan finds the service instance a bind or unbind command is for and returns it with its plan, along with the
+//arguments to hand to the plan's command, which need not look the instance up again. The instance is taken from
+//--service, then the profile, then the only Apigee service instance in the space, and is otherwise prompted for. An
+//empty plan with no error means only help was requested
+func (c *ApigeeBrokerPlugin) ResolvePlan(cliConnection plugin.CliConnection, args []string, bind bool) (*plugin_models.GetService_Model, Plan, []string, error) {
+	service := argValue(args[1:], "service")
+	if service == "" && (hasArg(args[1:], "h") || hasArg(args[1:], "help")) {
+		fmt.Fprintf(c.Out, "The flags of \"cf %s\" depend on the plan of the service instance.\nRun \"cf %s --service SERVICE_INSTANCE --help\" to see them\n", args[0], args[0])
+		return nil, "", args, nil
+	}
+	c.SetNonInteractive(hasArg(args[1:], "non-interactive"))
+
+	if service == "" {
+		err := c.ApplyProfile(argValue(args[1:], "profile"), map[string]UserInput{"service": UserInput{value: &service}}, flag.NewFlagSet(args[0], flag.ContinueOnError))
+		if err != nil {
+			return nil, "", args, err
+		}
+		err = c.SuggestService(cliConnection, &service, knownPlans...)
+		if err != nil {
+			return nil, "", args, err
+		}
+	}
+	if service == "" {
+		if c.nonInteractive {
+			return nil, "", args, missingInputError([]string{"--service"})
+		}
+		if bind {
+			fmt.Fprint(c.Out, "Service instance name to bind to [required]: ")
+		} else {
+			fmt.Fprint(c.Out, "Service instance name to unbind from [required]: ")
+		}
+		tmp, _ := c.input().ReadString('\n')
+		service = strings.TrimSpace(tmp)
+		err := c.CheckEmpty("service", service)
+		if err != nil {
+			return nil, "", args, err
+		}
+	}
+
+	instance, plan, err := c.ValidateService(cliConnection, service, "", bind)
+	if err != nil {
+		return nil, "", args, err
+	}
+	if argValue(args[1:], "service") == "" {
+		args = append(append([]string{}, args...), "--service", service)
+	}
+	return instance, plan, args, nil
+}
+
+//argValue returns the value given to a string flag in args, in any of the forms the flag package accepts
+func argValue(args []string, name string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		for _, prefix := range []string{"-" + name, "--" + name} {
+			if arg == prefix && i+1 < len(args) {
+				return args[i+1]
+			}
+			if strings.HasPrefix(arg, prefix+"=") {
+				return strings.TrimPrefix(arg, prefix+"=")
+			}
+		}
+	}
+	return ""
+}
+
+//hasArg reports whether a boolean flag is set in args
+func hasArg(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		for _, prefix := range []string{"-" + name, "--" + name} {
+			if arg == prefix || arg == prefix+"=true" {
+				return true
+			}
+		}
+	}
+	return false
+}
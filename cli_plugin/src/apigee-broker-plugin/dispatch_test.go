@@ -0,0 +1,150 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+)
+
+func TestUnifiedCommands(t *testing.T) {
+	orgArgs := []string{"--app", "myapp", "--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy", "--bearer", "tok"}
+	orgOnly := []plugin_models.GetServices_Model{testService("org-svc", ServiceOffering, PlanOrg), testService("db", "p-mysql", "100mb")}
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		services []plugin_models.GetServices_Model
+		want     [][]string
+		wantCode int
+		wantOut  string
+	}{
+		{
+			name: "bind dispatches to the coresident plan",
+			args: append(append([]string{"apigee-bind"}, coresidentArgs[1:]...), "--bearer", "tok", "--no-start"),
+			want: [][]string{{"bind-service", "myapp", "mgc-svc", "-c",
+				`{"org":"myorg","env":"test","action":"proxy bind","target_app_route":"myapp","target_app_port":"8080","edgemicro_key":"key","edgemicro_secret":"secret","bearer":"tok"}`}},
+		},
+		{
+			name: "bind dispatches to the microgateway plan",
+			args: append([]string{"apigee-bind", "--service=mg-svc", "--micro", "edgemicro.apps.example.com"}, orgArgs...),
+			want: [][]string{{"bind-route-service", "apps.example.com", "mg-svc", "--hostname", "myapp", "-c",
+				`{"org":"myorg","env":"test","action":"proxy","protocol":"","micro":"edgemicro.apps.example.com","bearer":"tok"}`}},
+		},
+		{
+			name:     "bind uses the only Apigee instance in the space",
+			args:     append([]string{"apigee-bind"}, orgArgs...),
+			services: orgOnly,
+			want: [][]string{{"bind-route-service", "apps.example.com", "org-svc", "--hostname", "myapp", "-c",
+				`{"org":"myorg","env":"test","action":"proxy","protocol":"","bearer":"tok"}`}},
+			wantOut: "Using service instance org-svc of the org plan",
+		},
+		{
+			name:  "bind prompts for the instance",
+			args:  append([]string{"apigee-bind"}, orgArgs...),
+			stdin: "org-svc\n\n\n",
+			want: [][]string{{"bind-route-service", "apps.example.com", "org-svc", "--hostname", "myapp", "-c",
+				`{"org":"myorg","env":"test","action":"proxy","protocol":"","bearer":"tok"}`}},
+			wantOut: "Service instance name to bind to [required]: ",
+		},
+		{
+			name:     "bind needs the instance non-interactively",
+			args:     append([]string{"apigee-bind", "--non-interactive"}, orgArgs...),
+			wantCode: ExitMissingInput,
+			wantOut:  "missing required values for: --service",
+		},
+		{
+			name:     "bind only accepts the flags of the instance's plan",
+			args:     append([]string{"apigee-bind", "--service", "org-svc", "--micro", "edgemicro.apps.example.com"}, orgArgs...),
+			wantCode: ExitUsage,
+			wantOut:  "flag provided but not defined: -micro",
+		},
+		{
+			name:     "bind rejects instances of other services",
+			args:     append([]string{"apigee-bind", "--service", "db"}, orgArgs...),
+			wantCode: ExitUsage,
+			wantOut:  `not the "apigee-edge" service`,
+		},
+		{
+			name:    "help without an instance",
+			args:    []string{"apigee-bind", "--help"},
+			wantOut: `Run "cf apigee-bind --service SERVICE_INSTANCE --help" to see them`,
+		},
+		{
+			name:    "help for an instance",
+			args:    []string{"apigee-unbind", "--service", "org-svc", "-h"},
+			wantOut: "Route of the application to unbind",
+		},
+		{
+			name: "unbind dispatches to a route plan",
+			args: []string{"apigee-unbind", "--service", "org-svc", "--app", "myapp"},
+			want: [][]string{{"unbind-route-service", "apps.example.com", "org-svc", "--hostname", "myapp"}},
+		},
+		{
+			name: "unbind dispatches to the coresident plan",
+			args: []string{"apigee-unbind", "-service=other-svc", "--app", "myapp"},
+			want: [][]string{{"unbind-service", "myapp", "other-svc"}},
+		},
+		{
+			name:    "deprecated commands still work",
+			args:    []string{"apigee-unbind-mgc", "--app", "myapp", "--service", "mgc-svc"},
+			want:    [][]string{{"unbind-service", "myapp", "mgc-svc"}},
+			wantOut: `Note: "cf apigee-unbind-mgc" is deprecated and will be removed in a future release, use "cf apigee-unbind" instead`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := &fakeCliConnection{services: test.services}
+			out, code := runPlugin(conn, test.args, test.stdin)
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d\noutput: %s", code, test.wantCode, out)
+			}
+			if !reflect.DeepEqual(conn.commands, test.want) {
+				t.Errorf("cf commands:\n got %q\nwant %q", conn.commands, test.want)
+			}
+			if !strings.Contains(out, test.wantOut) {
+				t.Errorf("output %q does not contain %q", out, test.wantOut)
+			}
+			// The plan's command uses the instance the dispatch looked up
+			if len(conn.lookups) > 1 {
+				t.Errorf("service instances looked up: %q, want at most one lookup", conn.lookups)
+			}
+		})
+	}
+}
+
+func TestArgValue(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--app", "myapp", "--service", "svc"}, "svc"},
+		{[]string{"-service=svc"}, "svc"},
+		{[]string{"--service"}, ""},
+		{[]string{"--", "--service", "svc"}, ""},
+		{[]string{"--services", "svc"}, ""},
+	}
+	for _, test := range tests {
+		if value := argValue(test.args, "service"); value != test.want {
+			t.Errorf("argValue(%q) = %q, want %q", test.args, value, test.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+//stripDryRun removes the global --dry-run flag from the arguments of a command, reporting whether it was given
+func stripDryRun(args []string) ([]string, bool) {
+	stripped := []string{args[0]}
+	dryRun := false
+	for i, arg := range args[1:] {
+		if arg == "--" {
+			stripped = append(stripped, args[i+1:]...)
+			break
+		}
+		switch arg {
+		case "-dry-run", "--dry-run", "-dry-run=true", "--dry-run=true":
+			dryRun = true
+		case "-dry-run=false", "--dry-run=false":
+		default:
+			stripped = append(stripped, arg)
+		}
+	}
+	return stripped, dryRun
+}
+
+//formatCommand formats a cf invocation as it would be typed, with secrets hidden
+func formatCommand(args []string) string {
+	quoted := []string{"cf"}
+	for _, arg := range RedactArgs(args) {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+//CfCommand runs a cf command, or only prints it when running with --dry-run
+func (c *ApigeeBrokerPlugin) CfCommand(cliConnection plugin.CliConnection, args ...string) error {
+	if c.dryRun {
+		fmt.Fprintf(c.Out, "Would run: %s\n", formatCommand(args))
+		return nil
+	}
+	// cf prints straight to the terminal, after anything the plugin has printed
+	c.flushOut()
+	_, err := cliConnection.CliCommand(args...)
+	if err != nil {
+		return NewCommandError(ExitCfCommand, err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dry_run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "app.jar")
+	writeTestZip(t, archive, map[string]string{"META-INF/MANIFEST.MF": "Manifest-Version: 1.0\n", "config/old.yaml": "old"})
+	config := filepath.Join(dir, "config")
+	writeTestFiles(t, config, map[string]string{"myorg-test-config.yaml": "edge_config: {}\n", "old.yaml": "new"})
+	plugins := filepath.Join(dir, "plugins")
+	writeTestFiles(t, plugins, map[string]string{"spikearrest/index.js": "module.exports = {}\n"})
+
+	tests := []struct {
+		name    string
+		args    []string
+		stdin   string
+		wantOut []string
+	}{
+		{
+			name: "bind with a route plan",
+			args: []string{"apigee-bind", "--dry-run", "--service", "org-svc", "--app", "myapp", "--apigee_org", "myorg",
+				"--apigee_env", "test", "--action", "proxy", "--user", "me", "--pass", "p@ss w0rd"},
+			wantOut: []string{`Would run: cf bind-route-service apps.example.com org-svc --hostname myapp -c '{"org":"myorg","env":"test","action":"proxy","protocol":"","user":"me","pass":"********"}'`},
+		},
+		{
+			name: "bind with the coresident plan and start",
+			args: append(append([]string{}, coresidentArgs...), "--bearer", "tok", "--start", "--dry-run=true"),
+			wantOut: []string{
+				`Would run: cf bind-service myapp mgc-svc -c '{"org":"myorg","env":"test","action":"proxy bind","target_app_route":"myapp","target_app_port":"8080","edgemicro_key":"key","edgemicro_secret":"********","bearer":"********"}'`,
+				"Would run: cf start myapp",
+			},
+		},
+		{
+			name:    "unbind",
+			args:    []string{"apigee-unbind", "-dry-run", "--service", "mgc-svc", "--app", "myapp"},
+			wantOut: []string{"Would run: cf unbind-service myapp mgc-svc"},
+		},
+		{
+			name: "push with a decorated archive",
+			args: []string{"apigee-push", "--dry-run", "--app", "myapp", "--archive", archive, "--config", config, "--plugins", plugins, "--coresident"},
+			wantOut: []string{
+				"Would write " + filepath.Join(dir, "apigee_app.jar") + " with these entries added to " + archive + ":",
+				"  config/\n  config/myorg-test-config.yaml\n  config/old.yaml (replaces the existing entry)\n",
+				"  plugins/\n  plugins/spikearrest/\n  plugins/spikearrest/index.js\n",
+				"Would run: cf push myapp -p " + filepath.Join(dir, "apigee_app.jar") + " --no-start",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := &fakeCliConnection{}
+			out, code := runPlugin(conn, test.args, test.stdin)
+			if code != ExitOK {
+				t.Fatalf("exit code %d\noutput: %s", code, out)
+			}
+			if len(conn.commands) > 0 {
+				t.Errorf("ran cf commands %q in a dry run", conn.commands)
+			}
+			for _, want := range test.wantOut {
+				if !strings.Contains(out, want) {
+					t.Errorf("output %q does not contain %q", out, want)
+				}
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "apigee_app.jar")); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote the decorated archive: %v", err)
+	}
+}
+
+func TestDryRunStillValidates(t *testing.T) {
+	conn := &fakeCliConnection{}
+	out, code := runPlugin(conn, []string{"apigee-bind-org", "--dry-run", "--service", "mgc-svc", "--app", "myapp",
+		"--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy", "--bearer", "tok"}, "")
+	if code != ExitUsage || strings.Contains(out, "Would run") {
+		t.Errorf("exit code %d, want %d\noutput: %s", code, ExitUsage, out)
+	}
+}
+
+func TestStripDryRun(t *testing.T) {
+	tests := []struct {
+		args   []string
+		want   []string
+		dryRun bool
+	}{
+		{[]string{"apigee-bind", "--app", "myapp"}, []string{"apigee-bind", "--app", "myapp"}, false},
+		{[]string{"apigee-bind", "--dry-run", "--app", "myapp"}, []string{"apigee-bind", "--app", "myapp"}, true},
+		{[]string{"apigee-bind", "--app", "myapp", "-dry-run=false"}, []string{"apigee-bind", "--app", "myapp"}, false},
+		{[]string{"apigee-bind", "--", "--dry-run"}, []string{"apigee-bind", "--", "--dry-run"}, false},
+	}
+	for _, test := range tests {
+		args, dryRun := stripDryRun(test.args)
+		if !reflect.DeepEqual(args, test.want) || dryRun != test.dryRun {
+			t.Errorf("stripDryRun(%q) = %q, %v, want %q, %v", test.args, args, dryRun, test.want, test.dryRun)
+		}
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	tests := map[string]string{
+		`{"org":"myorg","bearer":"tok","edgemicro_secret":"s","nested":{"pass":"p"}}`: `{"org":"myorg","bearer":"********","edgemicro_secret":"********","nested":{"pass":"p"}}`,
+		`{"Basic":"dXNlcjpwYXNz"}`: `{"Basic":"********"}`,
+		`not json`:                 `not json`,
+		`["bearer"]`:               `["bearer"]`,
+	}
+	for data, want := range tests {
+		if redacted := RedactJSON(data); redacted != want {
+			t.Errorf("RedactJSON(%s) = %s, want %s", data, redacted, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+//Exit codes used by every apigee-* command. These are documented in the plugin README and must stay stable so that
+//scripts wrapping the plugin can tell failures apart
+const (
+	ExitOK           = 0   // the command completed
+	ExitFailure      = 1   // any failure not covered below
+	ExitUsage        = 2   // unknown, malformed or conflicting flags and extra arguments
+	ExitMissingInput = 3   // a required value was not provided
+	ExitAuth         = 4   // Apigee credentials could not be read or obtained
+	ExitCfCommand    = 5   // a cf cli command, such as bind-service or push, failed
+	ExitArchive      = 6   // the application archive could not be read or rewritten
+	ExitCancelled    = 7   // the user cancelled at a prompt
+	ExitInterrupted  = 130 // the command was interrupted with Ctrl-C or terminated, as shells report SIGINT
+)
+
+//CommandError is returned by the command handlers so that Run can report a failure with its exit code
+type CommandError struct {
+	Code int
+	Err  error
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+//Unwrap returns the error the exit code was given to, for errors.Is and errors.As
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+//NewCommandError wraps err with the exit code it should be reported with
+func NewCommandError(code int, err error) error {
+	return &CommandError{Code: code, Err: err}
+}
+
+//newCommandErrorf formats a message and wraps it with the exit code it should be reported with
+func newCommandErrorf(code int, format string, a ...interface{}) error {
+	return NewCommandError(code, errors.New(fmt.Sprintf(format, a...)))
+}
+
+//ExitCode returns the exit code for an error returned by a command handler, also when the CommandError is wrapped
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code
+	}
+	return ExitFailure
+}
@@ -0,0 +1,44 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "no error", want: ExitOK},
+		{name: "plain error", err: errors.New("failed"), want: ExitFailure},
+		{name: "command error", err: newCommandErrorf(ExitArchive, "bad archive"), want: ExitArchive},
+		{name: "wrapped command error", err: fmt.Errorf("pushing: %w", newCommandErrorf(ExitCfCommand, "push failed")), want: ExitCfCommand},
+		{name: "wrapped twice", err: fmt.Errorf("a: %w", fmt.Errorf("b: %w", newCommandErrorf(ExitUsage, "bad flag"))), want: ExitUsage},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := ExitCode(test.err); code != test.want {
+				t.Errorf("ExitCode(%v) = %d, want %d", test.err, code, test.want)
+			}
+		})
+	}
+}
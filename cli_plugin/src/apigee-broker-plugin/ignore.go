@@ -0,0 +1,247 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+//CfIgnoreFile lists the files cf push leaves out of an application directory
+const CfIgnoreFile = ".cfignore"
+
+// cf push leaves these out of every application directory, whatever its .cfignore says
+var defaultIgnores = []string{".cfignore", "_darcs", ".DS_Store", ".git", ".gitignore", ".hg", "/manifest.yml", ".svn"}
+
+// the config and plugins directories also leave out editor swap and backup files, caches and local secrets
+var addedDirIgnores = []string{"*.swp", "*.swo", "*~", ".env", ".env.*", "**/node_modules/.cache/"}
+
+//ignorePattern is one line of an ignore file
+type ignorePattern struct {
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+//IgnoreList decides which files of an application directory to leave out, using the .gitignore pattern syntax
+//that .cfignore files share. Later patterns take precedence, and "!" includes again what an earlier pattern left out
+type IgnoreList struct {
+	patterns []ignorePattern
+}
+
+//NewIgnoreList returns a list ignoring what cf push always ignores
+func NewIgnoreList() *IgnoreList {
+	l := &IgnoreList{}
+	for _, pattern := range defaultIgnores {
+		l.Add(pattern)
+	}
+	return l
+}
+
+//Add adds one pattern. Blank lines and comments starting with "#" are skipped
+func (l *IgnoreList) Add(pattern string) {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return
+	}
+	p := ignorePattern{}
+	if strings.HasPrefix(pattern, "!") {
+		p.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		p.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return
+	}
+	// Patterns without a slash match at any depth, the others from the application directory
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	prefix := "^"
+	if !anchored {
+		prefix = "^(.*/)?"
+	}
+	compiled, err := regexp.Compile(prefix + globExpr(pattern) + "$")
+	if err != nil {
+		// Such as a character class with its range backwards, taken literally as git does
+		compiled = regexp.MustCompile(prefix + regexp.QuoteMeta(pattern) + "$")
+	}
+	p.pattern = compiled
+	l.patterns = append(l.patterns, p)
+}
+
+//AddFile adds the patterns of an ignore file, one per line. A missing file adds nothing
+func (l *IgnoreList) AddFile(file string) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error reading in ignore file \"%s\": %s", file, err.Error())
+		return errors.New(errorMsg)
+	}
+	defer f.Close()
+	return l.addLines(file, f)
+}
+
+func (l *IgnoreList) addLines(file string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		l.Add(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		errorMsg := fmt.Sprintf("Error reading in ignore file \"%s\": %s", file, err.Error())
+		return errors.New(errorMsg)
+	}
+	return nil
+}
+
+//DirIgnoreList returns what to leave out of a config or plugins directory: what cf push leaves out, editor and cache
+//files and local secrets, what the .cfignore file in the directory lists, and lastly the exclude patterns
+func DirIgnoreList(dir string, exclude []string) (*IgnoreList, error) {
+	l := NewIgnoreList()
+	for _, pattern := range addedDirIgnores {
+		l.Add(pattern)
+	}
+	err := l.AddFile(filepath.Join(dir, CfIgnoreFile))
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range exclude {
+		l.Add(pattern)
+	}
+	return l, nil
+}
+
+//Ignored reports whether the file or directory at name, relative to the application directory and using slashes,
+//is left out. The contents of an ignored directory are left out with it, so callers skip walking into it
+func (l *IgnoreList) Ignored(name string, dir bool) bool {
+	ignored := false
+	for _, p := range l.patterns {
+		if p.dirOnly && !dir {
+			continue
+		}
+		if p.pattern.MatchString(name) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+//globExpr turns a glob into a regular expression, where "*" and "?" stay within one path element and "**" spans any
+//number of them
+func globExpr(glob string) string {
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				expr.WriteString("(.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
+
+//SkipSummary counts the files left out of a copy and their sizes
+type SkipSummary struct {
+	Files int
+	Bytes int64
+}
+
+//add counts a file left out, or every file in a directory left out
+func (s *SkipSummary) add(fpath string, info os.FileInfo) {
+	if !info.IsDir() {
+		s.Files++
+		s.Bytes += info.Size()
+		return
+	}
+	filepath.Walk(fpath, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			s.Files++
+			s.Bytes += info.Size()
+		}
+		return nil
+	})
+}
+
+func (s SkipSummary) String() string {
+	if s.Files == 1 {
+		return fmt.Sprintf("1 file (%d bytes)", s.Bytes)
+	}
+	return fmt.Sprintf("%d files (%d bytes)", s.Files, s.Bytes)
+}
+
+//excludeValue is a flag.Value that lets --exclude be repeated, collecting every pattern
+type excludeValue struct {
+	patterns *[]string
+}
+
+func (e excludeValue) String() string {
+	if e.patterns == nil {
+		return ""
+	}
+	return strings.Join(*e.patterns, ",")
+}
+
+func (e excludeValue) Set(pattern string) error {
+	*e.patterns = append(*e.patterns, pattern)
+	return nil
+}
+
+//excludeFlag defines a repeatable --exclude flag and returns the patterns it collects
+func excludeFlag(flags *flag.FlagSet, usage string) *[]string {
+	patterns := new([]string)
+	flags.Var(excludeValue{patterns}, "exclude", usage)
+	return patterns
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreList(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns string
+		file     string
+		dir      bool
+		want     bool
+	}{
+		{name: "cf defaults", file: ".git", dir: true, want: true},
+		{name: "cf defaults at any depth", file: "lib/.DS_Store", want: true},
+		{name: "manifest at the root", file: "manifest.yml", want: true},
+		{name: "manifest deeper down", file: "docs/manifest.yml"},
+		{name: "plain file", file: "index.js"},
+		{name: "name at any depth", patterns: "node_modules", file: "lib/node_modules", dir: true, want: true},
+		{name: "glob", patterns: "*.log", file: "logs/app.log", want: true},
+		{name: "glob stays within a path element", patterns: "/lib/*.js", file: "lib/deep/a.js"},
+		{name: "anchored", patterns: "/build", file: "src/build", dir: true},
+		{name: "anchored at the root", patterns: "/build", file: "build", dir: true, want: true},
+		{name: "slash makes it anchored", patterns: "docs/api", file: "src/docs/api"},
+		{name: "double star", patterns: "**/fixtures/*.json", file: "a/b/fixtures/x.json", want: true},
+		{name: "trailing double star", patterns: "tmp/**", file: "tmp/a/b", want: true},
+		{name: "directories only", patterns: "out/", file: "out"},
+		{name: "directories only matches directories", patterns: "out/", file: "out", dir: true, want: true},
+		{name: "negation", patterns: "*.md\n!README.md", file: "README.md"},
+		{name: "later patterns win", patterns: "!README.md\n*.md", file: "README.md", want: true},
+		{name: "comments and blank lines", patterns: "# index.js\n\n", file: "index.js"},
+		{name: "character class", patterns: "test[0-9].js", file: "test1.js", want: true},
+		{name: "negated character class", patterns: "test[!0-9].js", file: "test1.js"},
+		{name: "backwards range taken literally", patterns: "a[z-a]", file: "a[z-a]", want: true},
+		{name: "escaped star", patterns: `\*.js`, file: "a.js"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := NewIgnoreList()
+			if err := l.addLines("test", strings.NewReader(test.patterns)); err != nil {
+				t.Fatal(err)
+			}
+			if got := l.Ignored(test.file, test.dir); got != test.want {
+				t.Errorf("Ignored(%q, %v) = %v, want %v", test.file, test.dir, got, test.want)
+			}
+		})
+	}
+}
+
+func TestDirIgnoreList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir_ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFiles(t, dir, map[string]string{CfIgnoreFile: "drafts/\n*.bak\n"})
+
+	tests := []struct {
+		name    string
+		exclude []string
+		file    string
+		dir     bool
+		want    bool
+	}{
+		{name: "config file", file: "myorg-test-config.yaml"},
+		{name: "local secrets", file: ".env", want: true},
+		{name: "local secrets of an environment", file: "custom/.env.local", want: true},
+		{name: "swap file", file: ".myorg-test-config.yaml.swp", want: true},
+		{name: "backup file", file: "myorg-test-config.yaml~", want: true},
+		{name: "nested cache", file: "custom/node_modules/.cache", dir: true, want: true},
+		{name: "node_modules kept", file: "custom/node_modules", dir: true},
+		{name: "version control", file: ".git", dir: true, want: true},
+		{name: "own .cfignore", file: "drafts", dir: true, want: true},
+		{name: "own .cfignore glob", file: "old.yaml.bak", want: true},
+		{name: "exclude", exclude: []string{"*.md", "test/"}, file: "test", dir: true, want: true},
+		{name: "exclude overrides .cfignore", exclude: []string{"!keep.bak"}, file: "keep.bak"},
+		{name: "exclude overrides defaults", exclude: []string{"!.env"}, file: ".env"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, err := DirIgnoreList(dir, test.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := l.Ignored(test.file, test.dir); got != test.want {
+				t.Errorf("Ignored(%q, %v) = %v, want %v", test.file, test.dir, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPushExcludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push_excludes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "app.jar")
+	writeTestZip(t, archive, map[string]string{"app/Main.class": "class"})
+	appDir := filepath.Join(dir, "app")
+	writeTestFiles(t, appDir, map[string]string{"index.js": ""})
+	config := filepath.Join(dir, "config")
+	writeTestFiles(t, config, map[string]string{
+		"myorg-test-config.yaml":  "edge_config: {}\n",
+		".env":                    "SECRET=1\n",
+		".git/HEAD":               "ref\n",
+		"drafts/next.yaml":        "draft\n",
+		"old.yaml.bak":            "old\n",
+		"notes.md":                "notes\n",
+		CfIgnoreFile:              "drafts/\n*.bak\n",
+		"myorg-test-config.yaml~": "backup\n",
+	})
+	plugins := filepath.Join(dir, "plugins")
+	writeTestFiles(t, plugins, map[string]string{
+		"custom/index.js":                     "module.exports = {}\n",
+		"custom/node_modules/dep/index.js":    "dep\n",
+		"custom/node_modules/.cache/tmp.json": "{}\n",
+		"custom/test/index.test.js":           "test\n",
+	})
+	// Left out: .env, .git/HEAD, drafts/next.yaml, old.yaml.bak, .cfignore and the backup from config, the cache from
+	// plugins, and notes.md and the tests given with --exclude
+	wantSkipped := "Left out 9 files (58 bytes)"
+	want := []string{"config/", "config/myorg-test-config.yaml", "plugins/", "plugins/custom/", "plugins/custom/index.js",
+		"plugins/custom/node_modules/", "plugins/custom/node_modules/dep/", "plugins/custom/node_modules/dep/index.js"}
+	args := []string{"apigee-push", "--app", "myapp", "--config", config, "--plugins", plugins, "--coresident", "--non-interactive",
+		"--exclude", "*.md", "--exclude", "test/"}
+
+	t.Run("archive", func(t *testing.T) {
+		out, code := runPlugin(&fakeCliConnection{}, append([]string{"apigee-push", "--archive", archive}, args[1:]...), "")
+		if code != 0 || !strings.Contains(out, wantSkipped) {
+			t.Fatalf("exit code %d\noutput %q does not contain %q", code, out, wantSkipped)
+		}
+		var got []string
+		for _, f := range zipEntries(t, filepath.Join(dir, "apigee_app.jar")) {
+			if !strings.HasPrefix(f.Name, "app/") {
+				got = append(got, f.Name)
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("entries added:\n got %q\nwant %q", got, want)
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		out, code := runPlugin(&fakeCliConnection{}, append([]string{"apigee-push", "--dry-run", "--archive", archive}, args[1:]...), "")
+		if code != 0 || !strings.Contains(out, "Would leave out 9 files (58 bytes) of the microgateway directories") || strings.Contains(out, ".env") {
+			t.Errorf("exit code %d\noutput: %s", code, out)
+		}
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		var staged []string
+		conn := &fakeCliConnection{onCommand: func(cmd []string) {
+			for name := range listFiles(t, cmd[len(cmd)-2]) {
+				staged = append(staged, name)
+			}
+		}}
+		out, code := runPlugin(conn, append([]string{"apigee-push", "--path", appDir}, args[1:]...), "")
+		if code != 0 || !strings.Contains(out, "leaving out 9 files (58 bytes)") {
+			t.Fatalf("exit code %d\noutput: %s", code, out)
+		}
+		sort.Strings(staged)
+		wantStaged := []string{"config/myorg-test-config.yaml", "index.js", "plugins/custom/index.js", "plugins/custom/node_modules/dep/index.js"}
+		if !reflect.DeepEqual(staged, wantStaged) {
+			t.Errorf("staged files:\n got %q\nwant %q", staged, wantStaged)
+		}
+	})
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+const manifestName = "META-INF/MANIFEST.MF"
+
+//ArchiveLayout is how an application archive is laid out, and where the microgateway directories go in it
+type ArchiveLayout struct {
+	Name   string
+	Prefix string
+}
+
+//Archive layouts apigee-push recognizes. Files at the root of a WAR are served by the servlet container, so the
+//microgateway directories, which hold credentials, go under WEB-INF where they are not. Jar launchers, including
+//Spring Boot's, ignore directories at the root that are not on the class path, so they stay at the root of jars
+var (
+	LayoutJar           = ArchiveLayout{Name: "jar"}
+	LayoutExecutableJar = ArchiveLayout{Name: "executable jar"}
+	LayoutBootJar       = ArchiveLayout{Name: "Spring Boot jar"}
+	LayoutWar           = ArchiveLayout{Name: "WAR", Prefix: "WEB-INF/"}
+	LayoutBootWar       = ArchiveLayout{Name: "Spring Boot WAR", Prefix: "WEB-INF/"}
+)
+
+// Spring Boot and servlet containers expect only jars in these directories, and Spring Boot needs them stored uncompressed
+var nestedJarDirs = []string{"BOOT-INF/lib/", "WEB-INF/lib/", "WEB-INF/lib-provided/"}
+
+//DetectLayout works out the layout of the archive from its entries and manifest
+func DetectLayout(archive string) (ArchiveLayout, error) {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return ArchiveLayout{}, err
+	}
+	defer r.Close()
+	return detectLayout(archive, r.File)
+}
+
+func detectLayout(archive string, files []*zip.File) (ArchiveLayout, error) {
+	var boot, war, bootLoader bool
+	var manifest *zip.File
+	for _, f := range files {
+		switch {
+		case strings.HasPrefix(f.Name, "BOOT-INF/"):
+			boot = true
+		case strings.HasPrefix(f.Name, "WEB-INF/"):
+			war = true
+		case strings.HasPrefix(f.Name, "org/springframework/boot/loader/"):
+			bootLoader = true
+		case f.Name == manifestName:
+			manifest = f
+		}
+	}
+	attributes, err := readManifest(manifest)
+	if err != nil {
+		return ArchiveLayout{}, err
+	}
+	_, bootManifest := attributes["Spring-Boot-Version"]
+	switch {
+	case boot:
+		return LayoutBootJar, nil
+	case war || strings.EqualFold(path.Ext(archive), ".war"):
+		if bootLoader || bootManifest {
+			return LayoutBootWar, nil
+		}
+		return LayoutWar, nil
+	case attributes["Main-Class"] != "":
+		return LayoutExecutableJar, nil
+	}
+	return LayoutJar, nil
+}
+
+//readManifest returns the main attributes of a jar manifest, joining continuation lines
+func readManifest(f *zip.File) (map[string]string, error) {
+	attributes := make(map[string]string)
+	if f == nil {
+		return attributes, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error reading in file from archive \"%s\": %s", f.Name, err.Error())
+		return nil, errors.New(errorMsg)
+	}
+	defer rc.Close()
+
+	scanner := bufio.NewScanner(io.LimitReader(rc, 1<<20))
+	last := ""
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			// The main attributes end at the first blank line
+			break
+		}
+		if strings.HasPrefix(line, " ") && last != "" {
+			attributes[last] += line[1:]
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		last = strings.TrimSpace(parts[0])
+		attributes[last] = strings.TrimSpace(parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		errorMsg := fmt.Sprintf("Error reading in file from archive \"%s\": %s", f.Name, err.Error())
+		return nil, errors.New(errorMsg)
+	}
+	return attributes, nil
+}
+
+//ParseArchivePrefix checks a directory given with --archive-prefix, returning it with a trailing slash, or "" for the
+//root of the archive. Prefixes leaving the archive, or inside a directory where only jars may be, are refused
+func ParseArchivePrefix(prefix string) (string, error) {
+	prefix = strings.Trim(strings.Replace(prefix, "\\", "/", -1), "/")
+	if prefix == "" {
+		return "", nil
+	}
+	prefix = path.Clean(prefix)
+	if prefix == "." {
+		return "", nil
+	}
+	if prefix == ".." || strings.HasPrefix(prefix, "../") || strings.Contains(prefix, ":") {
+		return "", newCommandErrorf(ExitUsage, "Error: --archive-prefix \"%s\" is outside of the archive", prefix)
+	}
+	prefix += "/"
+	for _, dir := range nestedJarDirs {
+		if strings.HasPrefix(prefix, dir) {
+			return "", newCommandErrorf(ExitUsage, "Error: --archive-prefix cannot be inside %s, which may only contain jars", dir)
+		}
+	}
+	return prefix, nil
+}
+
+//describePrefix says where in an archive entries under prefix go
+func describePrefix(prefix string) string {
+	if prefix == "" {
+		return "at the root of the archive"
+	}
+	return "under " + prefix
+}
+
+//manifestFirst orders files so the manifest, and the META-INF directory holding it, come first as
+//java.util.jar.JarInputStream requires, keeping the order of everything else
+func manifestFirst(files []*zip.File) []*zip.File {
+	ordered := make([]*zip.File, 0, len(files))
+	moved := make(map[*zip.File]bool)
+	for _, name := range []string{"META-INF/", manifestName} {
+		for _, f := range files {
+			if f.Name == name {
+				ordered = append(ordered, f)
+				moved[f] = true
+				break
+			}
+		}
+	}
+	for _, f := range files {
+		if !moved[f] {
+			ordered = append(ordered, f)
+		}
+	}
+	return ordered
+}
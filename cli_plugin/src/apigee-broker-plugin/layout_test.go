@@ -0,0 +1,249 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layout_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		archive string
+		files   map[string]string
+		want    ArchiveLayout
+	}{
+		{
+			name:    "library jar",
+			archive: "app.jar",
+			files:   map[string]string{"META-INF/MANIFEST.MF": "Manifest-Version: 1.0\n", "app/Lib.class": "class"},
+			want:    LayoutJar,
+		},
+		{
+			name:    "jar without a manifest",
+			archive: "app.jar",
+			files:   map[string]string{"app/Lib.class": "class"},
+			want:    LayoutJar,
+		},
+		{
+			name:    "executable jar",
+			archive: "app.jar",
+			files:   map[string]string{"META-INF/MANIFEST.MF": "Manifest-Version: 1.0\r\nMain-Class: app.Main\r\n", "app/Main.class": "class"},
+			want:    LayoutExecutableJar,
+		},
+		{
+			name:    "Main-Class of a later section",
+			archive: "app.jar",
+			files:   map[string]string{"META-INF/MANIFEST.MF": "Manifest-Version: 1.0\n\nName: app/\nMain-Class: app.Main\n"},
+			want:    LayoutJar,
+		},
+		{
+			name:    "Spring Boot jar",
+			archive: "app.jar",
+			files: map[string]string{
+				"META-INF/MANIFEST.MF":                                            "Main-Class: org.springframework.boot.loader.JarLaun\n cher\nStart-Class: app.Main\n",
+				"BOOT-INF/classes/app/Main.class":                                 "class",
+				"BOOT-INF/lib/dep.jar":                                            "jar",
+				"org/springframework/boot/loader/JarLauncher.class":               "class",
+				"org/springframework/boot/loader/ExecutableArchiveLauncher.class": "class",
+			},
+			want: LayoutBootJar,
+		},
+		{
+			name:    "WAR",
+			archive: "app.war",
+			files:   map[string]string{"META-INF/MANIFEST.MF": "Manifest-Version: 1.0\n", "WEB-INF/web.xml": "<web-app/>", "index.html": "hi"},
+			want:    LayoutWar,
+		},
+		{
+			name:    "WAR named like a jar",
+			archive: "app.jar",
+			files:   map[string]string{"WEB-INF/classes/app/Servlet.class": "class"},
+			want:    LayoutWar,
+		},
+		{
+			name:    "WAR without WEB-INF",
+			archive: "static.WAR",
+			files:   map[string]string{"index.html": "hi"},
+			want:    LayoutWar,
+		},
+		{
+			name:    "Spring Boot WAR",
+			archive: "app.war",
+			files: map[string]string{
+				"META-INF/MANIFEST.MF":                              "Main-Class: org.springframework.boot.loader.WarLauncher\nSpring-Boot-Version: 2.7.0\n",
+				"WEB-INF/classes/app/Main.class":                    "class",
+				"WEB-INF/lib-provided/tomcat.jar":                   "jar",
+				"org/springframework/boot/loader/WarLauncher.class": "class",
+			},
+			want: LayoutBootWar,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := filepath.Join(dir, test.archive)
+			writeTestZip(t, archive, test.files)
+			got, err := DetectLayout(archive)
+			if err != nil {
+				t.Fatalf("DetectLayout() error = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("DetectLayout() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseArchivePrefix(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		want    string
+		wantErr string
+	}{
+		{prefix: "", want: ""},
+		{prefix: "/", want: ""},
+		{prefix: ".", want: ""},
+		{prefix: "WEB-INF", want: "WEB-INF/"},
+		{prefix: "/BOOT-INF/classes/", want: "BOOT-INF/classes/"},
+		{prefix: `WEB-INF\microgateway`, want: "WEB-INF/microgateway/"},
+		{prefix: "a/./b/../c", want: "a/c/"},
+		{prefix: "..", wantErr: "outside of the archive"},
+		{prefix: "a/../../b", wantErr: "outside of the archive"},
+		{prefix: "C:/config", wantErr: "outside of the archive"},
+		{prefix: "BOOT-INF/lib", wantErr: "cannot be inside BOOT-INF/lib/"},
+		{prefix: "WEB-INF/lib-provided/x", wantErr: "cannot be inside WEB-INF/lib-provided/"},
+	}
+	for _, test := range tests {
+		got, err := ParseArchivePrefix(test.prefix)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("ParseArchivePrefix(%q) error = %v, want %q", test.prefix, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("ParseArchivePrefix(%q) = %q, %v, want %q", test.prefix, got, err, test.want)
+		}
+	}
+}
+
+func TestPushArchiveLayouts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push_layouts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "config")
+	writeTestFiles(t, config, map[string]string{"myorg-test-config.yaml": "edge_config: {}\n"})
+	plugins := filepath.Join(dir, "plugins")
+	writeTestFiles(t, plugins, map[string]string{"custom/index.js": "module.exports = {}\n"})
+
+	// The manifest is written after the classes, and the nested jar stored, to check both come out right
+	bootJar := filepath.Join(dir, "boot.jar")
+	writeCraftedZip(t, bootJar, []craftedEntry{
+		{name: "BOOT-INF/classes/app/Main.class", body: "class"},
+		{name: "META-INF/MANIFEST.MF", body: "Main-Class: org.springframework.boot.loader.JarLauncher\n"},
+		{name: "BOOT-INF/lib/dep.jar", body: "PK nested jar", stored: true},
+	})
+	war := filepath.Join(dir, "app.war")
+	writeTestZip(t, war, map[string]string{"META-INF/MANIFEST.MF": "Manifest-Version: 1.0\n", "WEB-INF/web.xml": "<web-app/>"})
+
+	tests := []struct {
+		name        string
+		archive     string
+		extra       []string
+		wantCode    int
+		wantOut     string
+		wantEntries []string
+	}{
+		{
+			name:        "Spring Boot jar",
+			archive:     bootJar,
+			wantOut:     "Spring Boot jar, adding the microgateway directories at the root of the archive",
+			wantEntries: []string{"config/myorg-test-config.yaml", "plugins/custom/index.js"},
+		},
+		{
+			name:        "WAR",
+			archive:     war,
+			wantOut:     "Set APIGEE_MICROGATEWAY_CONFIG_DIR to WEB-INF/config in the application's environment and APIGEE_MICROGATEWAY_CUST_PLUGINS to WEB-INF/plugins",
+			wantEntries: []string{"WEB-INF/config/myorg-test-config.yaml", "WEB-INF/plugins/custom/index.js"},
+		},
+		{
+			name:        "prefix override",
+			archive:     bootJar,
+			extra:       []string{"--archive-prefix", "BOOT-INF/classes"},
+			wantOut:     "adding the microgateway directories under BOOT-INF/classes/",
+			wantEntries: []string{"BOOT-INF/classes/config/myorg-test-config.yaml", "BOOT-INF/classes/plugins/custom/index.js"},
+		},
+		{
+			name:        "root override",
+			archive:     war,
+			extra:       []string{"--archive-prefix", ""},
+			wantOut:     "WAR, adding the microgateway directories at the root of the archive",
+			wantEntries: []string{"config/myorg-test-config.yaml"},
+		},
+		{
+			name:     "prefix among nested jars",
+			archive:  bootJar,
+			extra:    []string{"--archive-prefix", "BOOT-INF/lib/config"},
+			wantCode: ExitUsage,
+			wantOut:  "cannot be inside BOOT-INF/lib/",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := []string{"apigee-push", "--app", "myapp", "--archive", test.archive, "--config", config, "--plugins", plugins, "--coresident", "--non-interactive"}
+			out, code := runPlugin(&fakeCliConnection{}, append(args, test.extra...), "")
+			if code != test.wantCode || !strings.Contains(out, test.wantOut) {
+				t.Fatalf("exit code %d, want %d\noutput %q does not contain %q", code, test.wantCode, out, test.wantOut)
+			}
+			if test.wantCode != 0 {
+				return
+			}
+			decorated := filepath.Join(dir, "apigee_"+filepath.Base(test.archive))
+			entries := zipEntries(t, decorated)
+			if len(entries) < 2 || (entries[0].Name != "META-INF/MANIFEST.MF" && entries[1].Name != "META-INF/MANIFEST.MF") {
+				t.Errorf("manifest is not among the first two entries of %s", decorated)
+			}
+			names := make(map[string]*zip.File)
+			for _, f := range entries {
+				names[f.Name] = f
+			}
+			for _, name := range test.wantEntries {
+				if names[name] == nil {
+					t.Errorf("decorated archive is missing %s", name)
+				}
+			}
+			if nested := names["BOOT-INF/lib/dep.jar"]; nested != nil && nested.Method != zip.Store {
+				t.Errorf("nested jar method = %d, want stored", nested.Method)
+			}
+		})
+	}
+}
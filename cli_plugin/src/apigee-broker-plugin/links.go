@@ -0,0 +1,186 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//SymlinkPolicy is what copying a directory, or adding it to an archive, does with the symbolic links inside it
+type SymlinkPolicy int
+
+//Symbolic link policies. Whatever the policy, a link never brings in anything from outside of the directory
+const (
+	// SymlinkPreserve keeps links as links, provided they point inside the directory
+	SymlinkPreserve SymlinkPolicy = iota
+	// SymlinkFollow copies what links point to in their place, provided it is inside the directory
+	SymlinkFollow
+	// SymlinkReject refuses directories holding links
+	SymlinkReject
+)
+
+var symlinkPolicyNames = map[SymlinkPolicy]string{
+	SymlinkPreserve: "preserve",
+	SymlinkFollow:   "follow",
+	SymlinkReject:   "reject",
+}
+
+func (p SymlinkPolicy) String() string {
+	return symlinkPolicyNames[p]
+}
+
+//ParseSymlinkPolicy parses the value of --symlinks
+func ParseSymlinkPolicy(name string) (SymlinkPolicy, error) {
+	for policy, policyName := range symlinkPolicyNames {
+		if strings.EqualFold(name, policyName) {
+			return policy, nil
+		}
+	}
+	return 0, newCommandErrorf(ExitUsage, "Error: --symlinks must be \"preserve\", \"follow\" or \"reject\", not \"%s\"", name)
+}
+
+//walkDirFunc is called by walkDir for each entry under the directory walked, with its name relative to the directory,
+//the path its contents are read from, which for a followed link is what it points to, and the info of those contents.
+//Returning filepath.SkipDir for a directory skips its contents
+type walkDirFunc func(name, fpath string, info os.FileInfo) error
+
+//dirWalker walks a directory applying a symbolic link policy
+type dirWalker struct {
+	ctx    context.Context
+	root   string
+	policy SymlinkPolicy
+	fn     walkDirFunc
+	// walking holds the real paths of the directories being walked, from root down to the current one
+	walking map[string]bool
+}
+
+//walkDir calls fn for root, named ".", and everything under it in lexical order, applying policy to the symbolic
+//links it finds. Files that are neither regular files, directories nor links, such as sockets and devices, are left
+//out, as is everything about files apart from their contents, mode and modification time, such as extended
+//attributes and owners. It stops with ctx's error once ctx is cancelled
+func walkDir(ctx context.Context, root string, policy SymlinkPolicy, fn walkDirFunc) error {
+	// The directory itself may be reached through a link whatever the policy
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error reading in directory \"%s\" info: %s", root, err.Error())
+		return errors.New(errorMsg)
+	}
+	info, err := os.Stat(realRoot)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error reading in directory \"%s\" info: %s", root, err.Error())
+		return errors.New(errorMsg)
+	}
+	if !info.IsDir() {
+		errorMsg := "Specified file is not a directory"
+		return errors.New(errorMsg)
+	}
+	w := &dirWalker{ctx: ctx, root: realRoot, policy: policy, fn: fn, walking: make(map[string]bool)}
+	return w.walk(".", realRoot, info)
+}
+
+//walk visits the entry name at fpath. Directories are always visited at their real paths, so following a link to
+//one of them comes back to a directory being walked exactly when the links form a cycle
+func (w *dirWalker) walk(name, fpath string, info os.FileInfo) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		fpath, info, err = w.link(fpath, info)
+		if err != nil {
+			return err
+		}
+	}
+	if !info.IsDir() && !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+
+	err := w.fn(name, fpath, info)
+	if err == filepath.SkipDir && info.IsDir() {
+		return nil
+	}
+	if err != nil || !info.IsDir() {
+		return err
+	}
+
+	children, err := ioutil.ReadDir(fpath)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error reading in directory: %s", err.Error())
+		return errors.New(errorMsg)
+	}
+	w.walking[fpath] = true
+	defer delete(w.walking, fpath)
+	for _, child := range children {
+		err = w.walk(filepath.Join(name, child.Name()), filepath.Join(fpath, child.Name()), child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//link applies the policy to the symbolic link at fpath, returning the path and info to use for it
+func (w *dirWalker) link(fpath string, info os.FileInfo) (string, os.FileInfo, error) {
+	target, err := os.Readlink(fpath)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error reading symbolic link \"%s\": %s", fpath, err.Error())
+		return "", nil, errors.New(errorMsg)
+	}
+	switch w.policy {
+	case SymlinkReject:
+		errorMsg := fmt.Sprintf("Error: \"%s\" is a symbolic link to \"%s\", and symbolic links are rejected", fpath, target)
+		return "", nil, errors.New(errorMsg)
+
+	case SymlinkFollow:
+		real, err := filepath.EvalSymlinks(fpath)
+		if err != nil {
+			errorMsg := fmt.Sprintf("Error following symbolic link \"%s\": %s", fpath, err.Error())
+			return "", nil, errors.New(errorMsg)
+		}
+		if !withinDir(w.root, real) {
+			return "", nil, outsideLinkError(fpath, target, w.root)
+		}
+		info, err = os.Stat(real)
+		if err != nil {
+			errorMsg := fmt.Sprintf("Error following symbolic link \"%s\": %s", fpath, err.Error())
+			return "", nil, errors.New(errorMsg)
+		}
+		// Following a link into a directory already being walked, through however many other links, would never end
+		if info.IsDir() && w.walking[real] {
+			errorMsg := fmt.Sprintf("Error: Symbolic link \"%s\" to \"%s\" makes a cycle", fpath, target)
+			return "", nil, errors.New(errorMsg)
+		}
+		return real, info, nil
+	}
+
+	link := filepath.FromSlash(target)
+	if filepath.IsAbs(link) || filepath.VolumeName(link) != "" || !withinDir(w.root, filepath.Join(filepath.Dir(fpath), link)) {
+		return "", nil, outsideLinkError(fpath, target, w.root)
+	}
+	return fpath, info, nil
+}
+
+func outsideLinkError(fpath, target, root string) error {
+	errorMsg := fmt.Sprintf("Error: \"%s\" is a symbolic link to \"%s\", outside of \"%s\"", fpath, target, root)
+	return errors.New(errorMsg)
+}
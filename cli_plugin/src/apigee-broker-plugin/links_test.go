@@ -0,0 +1,381 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// readTree returns the regular files under dir with their contents, and the symbolic links with their targets
+func readTree(t *testing.T, dir string) (map[string]string, map[string]string) {
+	files := make(map[string]string)
+	links := make(map[string]string)
+	err := filepath.Walk(dir, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, fpath)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(fpath)
+			links[name] = target
+			return err
+		case info.Mode().IsRegular():
+			data, err := ioutil.ReadFile(fpath)
+			files[name] = string(data)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files, links
+}
+
+func TestCopyDirSymlinkPolicies(t *testing.T) {
+	files := map[string]string{"a.txt": "a", "sub/b.txt": "b"}
+	tests := []struct {
+		name  string
+		links map[string]string
+		// more are files written along with files
+		more      map[string]string
+		policy    SymlinkPolicy
+		wantErr   string
+		wantFiles map[string]string
+		wantLinks map[string]string
+	}{
+		{
+			name:      "preserve",
+			links:     map[string]string{"link.txt": "a.txt", "subdir": "sub", "sub/up": ".."},
+			policy:    SymlinkPreserve,
+			wantFiles: files,
+			wantLinks: map[string]string{"link.txt": "a.txt", "subdir": "sub", "sub/up": ".."},
+		},
+		{
+			name:      "preserve keeps broken links inside the directory",
+			links:     map[string]string{"gone": "missing.txt"},
+			policy:    SymlinkPreserve,
+			wantFiles: files,
+			wantLinks: map[string]string{"gone": "missing.txt"},
+		},
+		{
+			name:    "preserve refuses links leading outside",
+			links:   map[string]string{"sub/out": "../../outside.txt"},
+			policy:  SymlinkPreserve,
+			wantErr: "outside of",
+		},
+		{
+			name:    "preserve refuses absolute links",
+			links:   map[string]string{"abs": "/etc/passwd"},
+			policy:  SymlinkPreserve,
+			wantErr: "outside of",
+		},
+		{
+			name:      "follow",
+			links:     map[string]string{"link.txt": "a.txt", "subdir": "sub"},
+			policy:    SymlinkFollow,
+			wantFiles: map[string]string{"a.txt": "a", "sub/b.txt": "b", "link.txt": "a", "subdir/b.txt": "b"},
+			wantLinks: map[string]string{},
+		},
+		{
+			name:      "follow through a chain of links",
+			links:     map[string]string{"first": "second", "second": "a.txt"},
+			policy:    SymlinkFollow,
+			wantFiles: map[string]string{"a.txt": "a", "sub/b.txt": "b", "first": "a", "second": "a"},
+			wantLinks: map[string]string{},
+		},
+		{
+			name:    "follow refuses cycles",
+			links:   map[string]string{"sub/up": ".."},
+			policy:  SymlinkFollow,
+			wantErr: "makes a cycle",
+		},
+		{
+			name:    "follow refuses sibling directories linking to each other",
+			links:   map[string]string{"sub/tonext": "../next", "next/tosub": "../sub"},
+			more:    map[string]string{"next/c.txt": "c"},
+			policy:  SymlinkFollow,
+			wantErr: "makes a cycle",
+		},
+		{
+			name:   "follow allows several links to one directory",
+			links:  map[string]string{"x": "sub", "y": "sub", "next/tosub": "../sub"},
+			more:   map[string]string{"next/c.txt": "c"},
+			policy: SymlinkFollow,
+			wantFiles: map[string]string{"a.txt": "a", "sub/b.txt": "b", "next/c.txt": "c", "x/b.txt": "b", "y/b.txt": "b",
+				"next/tosub/b.txt": "b"},
+			wantLinks: map[string]string{},
+		},
+		{
+			name:    "follow refuses links to themselves",
+			links:   map[string]string{"self": "self"},
+			policy:  SymlinkFollow,
+			wantErr: "Error following symbolic link",
+		},
+		{
+			name:    "follow refuses links leading outside",
+			links:   map[string]string{"out": "../outside.txt"},
+			policy:  SymlinkFollow,
+			wantErr: "outside of",
+		},
+		{
+			name:    "follow refuses broken links",
+			links:   map[string]string{"gone": "missing.txt"},
+			policy:  SymlinkFollow,
+			wantErr: "Error following symbolic link",
+		},
+		{
+			name:    "reject",
+			links:   map[string]string{"sub/link.txt": "b.txt"},
+			policy:  SymlinkReject,
+			wantErr: "symbolic links are rejected",
+		},
+		{
+			name:      "reject without links",
+			policy:    SymlinkReject,
+			wantFiles: files,
+			wantLinks: map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "copy_links")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			writeTestFiles(t, dir, map[string]string{"outside.txt": "secret"})
+			source := filepath.Join(dir, "source")
+			writeTestFiles(t, source, files)
+			writeTestFiles(t, source, test.more)
+			for name, target := range test.links {
+				if err := os.Symlink(target, filepath.Join(source, filepath.FromSlash(name))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			dest := filepath.Join(dir, "dest")
+			err = CopyDir(context.Background(), source, dest, test.policy)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("CopyDir() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CopyDir() error = %v", err)
+			}
+			gotFiles, gotLinks := readTree(t, dest)
+			if !reflect.DeepEqual(gotFiles, test.wantFiles) {
+				t.Errorf("files:\n got %v\nwant %v", gotFiles, test.wantFiles)
+			}
+			if !reflect.DeepEqual(gotLinks, test.wantLinks) {
+				t.Errorf("links:\n got %v\nwant %v", gotLinks, test.wantLinks)
+			}
+		})
+	}
+}
+
+func TestCopyDirKeepsModesAndTimes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copy_modes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "source")
+	writeTestFiles(t, source, map[string]string{"run.sh": "#!/bin/sh\n", "private.yaml": "key: value\n", "readonly/file.txt": "x"})
+	// A named pipe would block copying forever, it is left out
+	if err := syscall.Mkfifo(filepath.Join(source, "pipe"), 0644); err != nil {
+		t.Logf("not checking named pipes: %v", err)
+	}
+
+	// Modes the umask would take bits away from, and a directory that cannot be written to
+	modes := map[string]os.FileMode{"run.sh": 0777, "private.yaml": 0600, "readonly/file.txt": 0444, "readonly": 0555, ".": 0750}
+	modTime := time.Date(2016, 5, 4, 3, 2, 1, 0, time.UTC)
+	for _, name := range []string{"run.sh", "private.yaml", "readonly/file.txt", "readonly", "."} {
+		fpath := filepath.Join(source, filepath.FromSlash(name))
+		if err := os.Chmod(fpath, modes[name]); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fpath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.Chmod(filepath.Join(source, "readonly"), 0755)
+
+	dest := filepath.Join(dir, "dest")
+	if err := CopyDir(context.Background(), source, dest, SymlinkPreserve); err != nil {
+		t.Fatalf("CopyDir() error = %v", err)
+	}
+	defer os.Chmod(filepath.Join(dest, "readonly"), 0755)
+
+	for name, mode := range modes {
+		info, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("%s was not copied: %v", name, err)
+			continue
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("%s has mode %v, want %v", name, info.Mode().Perm(), mode)
+		}
+		if !info.ModTime().Equal(modTime) {
+			t.Errorf("%s was modified at %v, want %v", name, info.ModTime(), modTime)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "pipe")); !os.IsNotExist(err) {
+		t.Errorf("named pipe was copied")
+	}
+}
+
+func TestRewriteArchiveKeepsModesAndTimes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rewrite_modes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	modTime := time.Date(2016, 5, 4, 3, 2, 2, 0, time.UTC)
+	entries := []struct {
+		name string
+		mode os.FileMode
+	}{
+		{name: "bin/", mode: os.ModeDir | 0750},
+		{name: "bin/start", mode: 0755},
+		{name: "conf/secret.properties", mode: 0600},
+	}
+	archive := filepath.Join(dir, "app.jar")
+	target, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(target)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: modTime}
+		header.SetMode(entry.mode)
+		if _, err := w.CreateHeader(header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	target.Close()
+	config := filepath.Join(dir, "config")
+	writeTestFiles(t, config, map[string]string{"myorg-test-config.yaml": "edge_config: {}\n", "start.sh": "#!/bin/sh\n"})
+	if err := os.Chmod(filepath.Join(config, "start.sh"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(config, "start.sh"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	entries = append(entries, struct {
+		name string
+		mode os.FileMode
+	}{name: "config/start.sh", mode: 0750})
+
+	decorated := filepath.Join(dir, "apigee_app.jar")
+	if _, err := RewriteArchive(context.Background(), archive, decorated, RewriteOptions{Dirs: []string{config}}); err != nil {
+		t.Fatalf("RewriteArchive() error = %v", err)
+	}
+	got := make(map[string]*zip.File)
+	for _, f := range zipEntries(t, decorated) {
+		got[f.Name] = f
+	}
+	for _, entry := range entries {
+		f := got[entry.name]
+		if f == nil {
+			t.Errorf("decorated archive is missing %s", entry.name)
+			continue
+		}
+		if f.Mode() != entry.mode {
+			t.Errorf("decorated %s has mode %v, want %v", entry.name, f.Mode(), entry.mode)
+		}
+		if !f.Modified.Equal(modTime) {
+			t.Errorf("decorated %s was modified at %v, want %v", entry.name, f.Modified, modTime)
+		}
+	}
+}
+
+func TestPushSymlinkPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push_links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "app.jar")
+	writeTestZip(t, archive, map[string]string{"app/Main.class": "class"})
+	config := filepath.Join(dir, "config")
+	writeTestFiles(t, config, map[string]string{"myorg-test-config.yaml": "edge_config: {}\n"})
+	if err := os.Symlink("myorg-test-config.yaml", filepath.Join(config, "current.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"apigee-push", "--app", "myapp", "--archive", archive, "--config", config, "--coresident", "--non-interactive"}
+
+	tests := []struct {
+		name     string
+		symlinks string
+		wantCode int
+		wantOut  string
+		wantLink bool
+	}{
+		{name: "preserved by default", wantLink: true},
+		{name: "followed", symlinks: "follow"},
+		{name: "rejected", symlinks: "reject", wantCode: ExitArchive, wantOut: "symbolic links are rejected"},
+		{name: "unknown policy", symlinks: "copy", wantCode: ExitUsage, wantOut: `--symlinks must be "preserve", "follow" or "reject"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testArgs := args
+			if test.symlinks != "" {
+				testArgs = append(append([]string{}, args...), "--symlinks", test.symlinks)
+			}
+			out, code := runPlugin(&fakeCliConnection{}, testArgs, "")
+			if code != test.wantCode || !strings.Contains(out, test.wantOut) {
+				t.Fatalf("exit code %d, want %d\noutput %q does not contain %q", code, test.wantCode, out, test.wantOut)
+			}
+			if code != 0 {
+				return
+			}
+			for _, f := range zipEntries(t, filepath.Join(dir, "apigee_app.jar")) {
+				if f.Name != "config/current.yaml" {
+					continue
+				}
+				if isLink := f.Mode()&os.ModeSymlink != 0; isLink != test.wantLink {
+					t.Errorf("config/current.yaml is a link: %v, want %v", isLink, test.wantLink)
+				}
+				if body := readZipEntry(t, filepath.Join(dir, "apigee_app.jar"), f.Name); test.wantLink && body != "myorg-test-config.yaml" || !test.wantLink && body != "edge_config: {}\n" {
+					t.Errorf("config/current.yaml holds %q", body)
+				}
+			}
+		})
+	}
+}
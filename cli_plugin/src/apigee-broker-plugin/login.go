@@ -0,0 +1,271 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+//DefaultTokenURL is Apigee Edge's OAuth token endpoint
+const DefaultTokenURL = "https://login.apigee.com/oauth/token"
+
+// apigeeClientCredentials are the public client id and secret ("edgecli:edgeclisecret") used by Apigee's own tools
+const apigeeClientCredentials = "ZWRnZWNsaTplZGdlY2xpc2VjcmV0"
+
+// tokens that expire within this window are refreshed before use
+const tokenRefreshMargin = time.Minute
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+//TokenCache is the Apigee access token saved by apigee-login, along with what is needed to refresh it
+type TokenCache struct {
+	TokenURL     string    `json:"token_url"`
+	User         string    `json:"user,omitempty"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+//RequestToken exchanges a password or refresh token grant for an access token at tokenURL
+func RequestToken(tokenURL string, form url.Values, mfa string) (*TokenCache, error) {
+	endpoint, err := url.Parse(tokenURL)
+	if err != nil {
+		return nil, newCommandErrorf(ExitUsage, "Error: Invalid token URL \"%s\": %s", tokenURL, err.Error())
+	}
+	if mfa != "" {
+		query := endpoint.Query()
+		query.Set("mfa_token", mfa)
+		endpoint.RawQuery = query.Encode()
+	}
+
+	req, err := http.NewRequest("POST", endpoint.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, newCommandErrorf(ExitAuth, "Error building token request: %s", err.Error())
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Basic "+apigeeClientCredentials)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, newCommandErrorf(ExitAuth, "Error requesting Apigee token: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, newCommandErrorf(ExitAuth, "Error reading Apigee token response: %s", err.Error())
+	}
+	var token tokenResponse
+	err = json.Unmarshal(body, &token)
+	if resp.StatusCode != http.StatusOK {
+		reason := resp.Status
+		if err == nil && token.ErrorDescription != "" {
+			reason = token.ErrorDescription
+		} else if err == nil && token.Error != "" {
+			reason = token.Error
+		}
+		return nil, newCommandErrorf(ExitAuth, "Apigee token request failed: %s", reason)
+	}
+	if err != nil || token.AccessToken == "" {
+		return nil, newCommandErrorf(ExitAuth, "Error: Apigee token response did not contain an access token")
+	}
+
+	return &TokenCache{
+		TokenURL:     tokenURL,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresAt:    time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}, nil
+}
+
+//tokenCachePath is the file apigee-login saves tokens to
+func (c *ApigeeBrokerPlugin) tokenCachePath() (string, error) {
+	dir, err := c.pluginDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "token.json"), nil
+}
+
+//LoadTokenCache reads the token saved by apigee-login, returning nil if there is none
+func (c *ApigeeBrokerPlugin) LoadTokenCache() (*TokenCache, error) {
+	path, err := c.tokenCachePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newCommandErrorf(ExitAuth, "Error reading cached Apigee token: %s", err.Error())
+	}
+	var cache TokenCache
+	err = json.Unmarshal(data, &cache)
+	if err != nil {
+		return nil, newCommandErrorf(ExitAuth, "Error parsing cached Apigee token \"%s\": %s", path, err.Error())
+	}
+	return &cache, nil
+}
+
+//SaveTokenCache writes tokens to the cache file, readable and writable only by the current user
+func (c *ApigeeBrokerPlugin) SaveTokenCache(cache *TokenCache) error {
+	path, err := c.tokenCachePath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return newCommandErrorf(ExitFailure, "Error making directory \"%s\": %s", filepath.Dir(path), err.Error())
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return newCommandErrorf(ExitFailure, "Error encoding Apigee token: %s", err.Error())
+	}
+	err = ioutil.WriteFile(path, data, 0600)
+	if err == nil {
+		// WriteFile only applies the mode to new files
+		err = os.Chmod(path, 0600)
+	}
+	if err != nil {
+		return newCommandErrorf(ExitFailure, "Error saving Apigee token: %s", err.Error())
+	}
+	return nil
+}
+
+//CachedBearer returns the cached access token, refreshing it first if it has expired. It returns "" when
+//apigee-login has not been run
+func (c *ApigeeBrokerPlugin) CachedBearer() (string, error) {
+	if c.CfHome == "" {
+		return "", nil
+	}
+	cache, err := c.LoadTokenCache()
+	if err != nil || cache == nil {
+		return "", err
+	}
+	c.redactor.Add(cache.AccessToken, cache.RefreshToken)
+	if time.Now().Add(tokenRefreshMargin).Before(cache.ExpiresAt) {
+		return cache.AccessToken, nil
+	}
+	if cache.RefreshToken == "" {
+		return "", newCommandErrorf(ExitAuth, "Cached Apigee token has expired. Run \"cf apigee-login\" again")
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", cache.RefreshToken)
+	refreshed, err := RequestToken(cache.TokenURL, form, "")
+	if err != nil {
+		return "", newCommandErrorf(ExitAuth, "Cached Apigee token has expired and could not be refreshed, run \"cf apigee-login\" again: %s", err.Error())
+	}
+	refreshed.User = cache.User
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = cache.RefreshToken
+	}
+	err = c.SaveTokenCache(refreshed)
+	if err != nil {
+		return "", err
+	}
+	return refreshed.AccessToken, nil
+}
+
+//ApigeeLoginCommand exchanges Apigee credentials for an access token and caches it for the bind commands
+func (c *ApigeeBrokerPlugin) ApigeeLoginCommand(cliConnection plugin.CliConnection, args []string) error {
+	flags := flag.NewFlagSet("apigee-login", flag.ContinueOnError)
+	flags.SetOutput(c.Out)
+	generalConfig := map[string]UserInput{
+		"user": UserInput{
+			value:         flags.String("user", "", "Apigee username: "),
+			requiredInput: true,
+			hiddenInput:   false,
+		},
+		"pass": UserInput{
+			value:         flags.String("pass", "", "Apigee password: "),
+			requiredInput: true,
+			hiddenInput:   true,
+		},
+		"mfa": UserInput{
+			value:         flags.String("mfa", "", "Multi-factor authentication code (leave empty if not enabled): "),
+			requiredInput: false,
+			hiddenInput:   false,
+		},
+	}
+	tokenURL := flags.String("token_url", "", "Apigee OAuth token endpoint")
+	nonInteractive := flags.Bool("non-interactive", false, "Fail instead of prompting for missing values")
+
+	// Parse from [1] since [0] is command name
+	err := flags.Parse(args[1:])
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return newCommandErrorf(ExitUsage, "Error: Couldn't parse arguments: %s", err.Error())
+	}
+
+	// Check to make sure there are no extra arguments
+	if flags.NArg() > 0 {
+		return newCommandErrorf(ExitUsage, "Error: Unknown extra arguments")
+	}
+	c.SetNonInteractive(*nonInteractive)
+
+	// Prompt in the order a login form would
+	err = c.ValidateInputs(generalConfig, []string{"user", "pass", "mfa"}, nil, flags)
+	if err != nil {
+		return err
+	}
+
+	if *tokenURL == "" {
+		*tokenURL = DefaultTokenURL
+	}
+	form := url.Values{}
+	form.Set("grant_type", "password")
+	form.Set("username", *generalConfig["user"].value)
+	form.Set("password", *generalConfig["pass"].value)
+	cache, err := RequestToken(*tokenURL, form, *generalConfig["mfa"].value)
+	if err != nil {
+		return err
+	}
+	c.redactor.Add(cache.AccessToken, cache.RefreshToken)
+	cache.User = *generalConfig["user"].value
+
+	err = c.SaveTokenCache(cache)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "Logged in to Apigee as %s\n", cache.User)
+	return nil
+}
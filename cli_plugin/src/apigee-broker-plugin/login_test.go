@@ -0,0 +1,173 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTokenServer stands in for Apigee's OAuth endpoint. It accepts the password "secret" with the MFA code
+// "123456", and the refresh token "refresh-1"
+func newTokenServer(t *testing.T, requests *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Basic "+apigeeClientCredentials {
+			t.Errorf("token request without client credentials: %q", r.Header.Get("Authorization"))
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		grant := r.PostForm.Get("grant_type")
+		*requests = append(*requests, grant)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case grant == "password" && r.PostForm.Get("username") == "me@example.com" && r.PostForm.Get("password") == "secret" && r.URL.Query().Get("mfa_token") == "123456":
+			fmt.Fprint(w, `{"access_token":"access-1","refresh_token":"refresh-1","expires_in":1799,"token_type":"bearer"}`)
+		case grant == "refresh_token" && r.PostForm.Get("refresh_token") == "refresh-1":
+			fmt.Fprint(w, `{"access_token":"access-2","expires_in":1799,"token_type":"bearer"}`)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"unauthorized","error_description":"Bad credentials"}`)
+		}
+	}))
+}
+
+func TestLoginCachesTokens(t *testing.T) {
+	home, err := ioutil.TempDir("", "cf_home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	var requests []string
+	server := newTokenServer(t, &requests)
+	defer server.Close()
+
+	conn := &fakeCliConnection{}
+	out, code := runPluginWithHome(home, conn, "apigee-login", "--user", "me@example.com", "--pass", "wrong", "--mfa", "123456", "--token_url", server.URL, "--non-interactive")
+	if code != ExitAuth || !strings.Contains(out, "Bad credentials") {
+		t.Errorf("bad credentials: got code %d and output %q", code, out)
+	}
+
+	out, code = runPluginWithHome(home, conn, "apigee-login", "--user", "me@example.com", "--pass", "secret", "--mfa", "123456", "--token_url", server.URL, "--non-interactive")
+	if code != ExitOK {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+
+	path := filepath.Join(home, ".cf", "apigee-broker-plugin", "token.json")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("token cache mode %v, want 0600", info.Mode().Perm())
+	}
+
+	out, code = runPluginWithHome(home, conn, "apigee-bind-org", "--app", "myapp", "--domain", "apps.example.com", "--service", "org-svc",
+		"--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy", "--non-interactive")
+	if code != ExitOK {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+
+	// Expire the cached token so the next bind has to refresh it
+	data, _ := ioutil.ReadFile(path)
+	var cache TokenCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		t.Fatal(err)
+	}
+	cache.ExpiresAt = time.Now().Add(-time.Hour)
+	data, _ = json.Marshal(cache)
+	ioutil.WriteFile(path, data, 0600)
+
+	out, code = runPluginWithHome(home, conn, "apigee-unbind-mgc", "--app", "myapp", "--service", "mgc-svc", "--non-interactive")
+	if code != ExitOK {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+	out, code = runPluginWithHome(home, conn, "apigee-bind-mgc", "--app", "myapp", "--service", "mgc-svc", "--apigee_org", "myorg",
+		"--apigee_env", "test", "--edgemicro_key", "key", "--edgemicro_secret", "secret", "--target_app_route", "myapp",
+		"--target_app_port", "8080", "--action", "bind", "--no-start", "--non-interactive")
+	if code != ExitOK {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+
+	want := [][]string{
+		{"bind-route-service", "apps.example.com", "org-svc", "--hostname", "myapp", "-c",
+			`{"org":"myorg","env":"test","action":"proxy","protocol":"","bearer":"access-1"}`},
+		{"unbind-service", "myapp", "mgc-svc"},
+		{"bind-service", "myapp", "mgc-svc", "-c",
+			`{"org":"myorg","env":"test","action":"bind","target_app_route":"myapp","target_app_port":"8080","edgemicro_key":"key","edgemicro_secret":"secret","bearer":"access-2"}`},
+	}
+	if !reflect.DeepEqual(conn.commands, want) {
+		t.Errorf("cf commands:\n got %q\nwant %q", conn.commands, want)
+	}
+	if !reflect.DeepEqual(requests, []string{"password", "password", "refresh_token"}) {
+		t.Errorf("token requests %q", requests)
+	}
+
+	data, _ = ioutil.ReadFile(path)
+	if err := json.Unmarshal(data, &cache); err != nil {
+		t.Fatal(err)
+	}
+	if cache.AccessToken != "access-2" || cache.RefreshToken != "refresh-1" || cache.User != "me@example.com" {
+		t.Errorf("refreshed cache %+v", cache)
+	}
+}
+
+func TestExplicitCredentialsSkipTokenCache(t *testing.T) {
+	home, err := ioutil.TempDir("", "cf_home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	c := &ApigeeBrokerPlugin{CfHome: home}
+	if err := c.SaveTokenCache(&TokenCache{AccessToken: "cached", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := &fakeCliConnection{}
+	out, code := runPluginWithHome(home, conn, "apigee-bind-org", "--app", "myapp", "--domain", "apps.example.com", "--service", "org-svc",
+		"--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy", "--user", "me", "--pass", "pw", "--non-interactive")
+	if code != ExitOK {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+	if got := conn.commands[0][6]; !strings.Contains(got, `"user":"me"`) || strings.Contains(got, "cached") {
+		t.Errorf("bind parameters %s", got)
+	}
+}
+
+func TestExpiredTokenWithoutRefreshToken(t *testing.T) {
+	home, err := ioutil.TempDir("", "cf_home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	c := &ApigeeBrokerPlugin{CfHome: home}
+	if err := c.SaveTokenCache(&TokenCache{AccessToken: "cached", ExpiresAt: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CachedBearer(); ExitCode(err) != ExitAuth {
+		t.Errorf("got %v, want an auth failure", err)
+	}
+}
@@ -0,0 +1,262 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+//DecoratorBuildpack is the buildpack that runs microgateway next to an application bound to a coresident instance
+const DecoratorBuildpack = "microgateway_decorator"
+
+// arguments made only of these characters do not need quoting in a shell script
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+//shellQuote quotes an argument for a POSIX shell
+func shellQuote(arg string) string {
+	if shellSafe.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+//placeholder returns value, or a placeholder naming the flag when it is empty, recording the flag in missing
+func placeholder(value, name string, missing *[]string) string {
+	if value != "" {
+		return value
+	}
+	*missing = append(*missing, "--"+name)
+	return "<" + name + ">"
+}
+
+//ApigeeMigrateCommand prints, or writes as a shell script, the standard cf commands that replace apigee-push and
+//apigee-bind-mgc for the microgateway-coresident plan. The bind parameters are written to a JSON file passed to
+//"cf bind-service -c". Missing values are left as placeholders instead of being prompted for
+func (c *ApigeeBrokerPlugin) ApigeeMigrateCommand(cliConnection plugin.CliConnection, args []string) error {
+	flags := flag.NewFlagSet("apigee-migrate", flag.ContinueOnError)
+	flags.SetOutput(c.Out)
+	generalConfig := map[string]UserInput{
+		"app":              UserInput{value: flags.String("app", "", "Name of application")},
+		"service":          UserInput{value: flags.String("service", "", "Service instance name to bind to")},
+		"apigee_org":       UserInput{value: flags.String("apigee_org", "", "Apigee organization")},
+		"apigee_env":       UserInput{value: flags.String("apigee_env", "", "Apigee environment")},
+		"edgemicro_key":    UserInput{value: flags.String("edgemicro_key", "", "Microgateway key")},
+		"edgemicro_secret": UserInput{value: flags.String("edgemicro_secret", "", "Microgateway secret")},
+		"target_app_route": UserInput{value: flags.String("target_app_route", "", "Target application route")},
+		"target_app_port":  UserInput{value: flags.String("target_app_port", "", "Target application port")},
+		"action":           UserInput{value: actionFlag(flags, "Action to take (\"bind\", \"proxy bind\", or \"proxy\")")},
+	}
+	bearer := flags.String("bearer", "", "Apigee authentication token")
+	basic := flags.String("basic", "", "Apigee basic authentication credentials")
+	user := flags.String("user", "", "Apigee username")
+	pass := flags.String("pass", "", "Apigee password")
+	credentialSources := AddCredentialFlags(flags)
+	archive := flags.String("archive", "", "Path to the application archive")
+	path := flags.String("path", "", "Path to the application directory")
+	exclude := excludeFlag(flags, "Pattern, as in .cfignore, of files in the config and plugins directories to leave out. Can be repeated")
+	config := flags.String("config", "", "Path to configuration directory that contains a microgateway yaml")
+	plugins := flags.String("plugins", "", "Path to configuration directory that contains custom plugins")
+	noStart := flags.Bool("no-start", false, "Do not start the application")
+	buildpack := flags.String("buildpack", "nodejs_buildpack", "Buildpack of the application, run after the microgateway decorator")
+	paramsFile := flags.String("params-file", "", "File to write the bind parameters to")
+	script := flags.String("script", "", "Write the commands to this shell script instead of printing them")
+	profile := flags.String("profile", "", "Name of the profile to fill unset flags from")
+	// Accepted so that apigee-bind-mgc and apigee-push command lines can be migrated unchanged
+	flags.Bool("start", false, "Start the application after binding")
+	flags.Bool("coresident", false, "The application will be used with the microgateway-coresident plan")
+	flags.Bool("non-interactive", false, "Fail instead of prompting for missing values")
+	// Accepted for the same reason, though they only change how apigee-push decorates an archive
+	flags.Int64("max-extracted-size", 0, "Most megabytes the archive may extract to")
+	flags.Int("max-entries", 0, "Most entries the archive may contain")
+	flags.Int64("max-ratio", 0, "Most a file in the archive may be compressed")
+	flags.String("symlinks", "", "What to do with symbolic links in the directories added")
+	flags.Bool("reproducible", false, "Write the same archive byte for byte from the same inputs")
+	flags.String("archive-prefix", "", "Directory in the archive to add the config and plugins directories to")
+	flags.Bool("verify-only", false, "Check the decorated copy of --archive without pushing")
+
+	// Parse from [1] since [0] is command name
+	err := flags.Parse(args[1:])
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return newCommandErrorf(ExitUsage, "Error: Couldn't parse arguments: %s", err.Error())
+	}
+
+	// Check to make sure there are no extra arguments
+	if flags.NArg() > 0 {
+		return newCommandErrorf(ExitUsage, "Error: Unknown extra arguments")
+	}
+	if *archive != "" && *path != "" {
+		return newCommandErrorf(ExitUsage, "Error: --archive and --path cannot be used together")
+	}
+	archiveOnly := make([]string, 0)
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "max-extracted-size", "max-entries", "max-ratio", "symlinks", "reproducible", "archive-prefix", "verify-only":
+			archiveOnly = append(archiveOnly, "--"+f.Name)
+		}
+	})
+
+	err = c.ApplyProfile(*profile, generalConfig, flags)
+	if err != nil {
+		return err
+	}
+
+	// Only credentials given explicitly are written out, a cached token would expire before the script is run
+	if *bearer == "" && *credentialSources.BearerFile != "" {
+		*bearer, err = readSecretFile(*credentialSources.BearerFile)
+		if err != nil {
+			return err
+		}
+	}
+	if *pass == "" && *credentialSources.PassFile != "" {
+		*pass, err = readSecretFile(*credentialSources.PassFile)
+		if err != nil {
+			return err
+		}
+	}
+	if *bearer == "" && *credentialSources.BearerStdin {
+		data, err := ioutil.ReadAll(c.input())
+		if err != nil {
+			return newCommandErrorf(ExitAuth, "Error reading bearer token from stdin: %s", err.Error())
+		}
+		*bearer = strings.TrimSpace(string(data))
+	}
+	c.redactor.Add(*bearer, *basic, *pass, *generalConfig["edgemicro_secret"].value)
+
+	missing := make([]string, 0)
+	value := func(key string) string {
+		return placeholder(*generalConfig[key].value, key, &missing)
+	}
+	app := value("app")
+	service := value("service")
+	org := value("apigee_org")
+	env := value("apigee_env")
+	action := value("action")
+	if *generalConfig["action"].value != "" {
+		actions, err := ParseActions(action)
+		if err != nil {
+			return err
+		}
+		action = actions.String()
+	}
+	params := CoresidentBindParams{
+		Org:             org,
+		Env:             env,
+		Action:          action,
+		TargetAppRoute:  value("target_app_route"),
+		TargetAppPort:   value("target_app_port"),
+		EdgemicroKey:    value("edgemicro_key"),
+		EdgemicroSecret: value("edgemicro_secret"),
+		AuthParams:      NewAuthParams(*bearer, *basic, *user, *pass),
+	}
+	if params.AuthParams == (AuthParams{}) {
+		params.Bearer = placeholder("", "bearer", &missing)
+	} else if params.User != "" || params.Pass != "" {
+		params.User = placeholder(params.User, "user", &missing)
+		params.Pass = placeholder(params.Pass, "pass", &missing)
+	}
+	jsonString, err := MarshalBindParams(params)
+	if err != nil {
+		return err
+	}
+
+	if *paramsFile == "" {
+		*paramsFile = "apigee-bind-params.json"
+		if *generalConfig["app"].value != "" {
+			*paramsFile = *generalConfig["app"].value + "-apigee-bind-params.json"
+		}
+	}
+
+	lines := make([]string, 0)
+	if *archive != "" {
+		lines = append(lines, "# Note: the "+DecoratorBuildpack+" buildpack does not support Java applications")
+	}
+	if *config != "" {
+		lines = append(lines, "# Copy "+*config+" into the application and set APIGEE_MICROGATEWAY_CONFIG_DIR to its path in the manifest env")
+	}
+	if *plugins != "" {
+		lines = append(lines, "# Copy "+*plugins+" into the application and set APIGEE_MICROGATEWAY_CUST_PLUGINS to its path in the manifest env")
+	}
+	if len(*exclude) > 0 && (*config != "" || *plugins != "") {
+		lines = append(lines, "# Leave files matching "+strings.Join(*exclude, ", ")+" out of the copies, or list them in "+CfIgnoreFile)
+	}
+	if len(archiveOnly) > 0 {
+		lines = append(lines, "# Note: "+strings.Join(archiveOnly, ", ")+" only apply to archives decorated by apigee-push and are left out")
+	}
+	pushArgs := []string{"cf", "push", app, "--no-start"}
+	v3PushArgs := []string{"cf", "v3-push", app, "-b", DecoratorBuildpack, "-b", *buildpack}
+	if *archive != "" {
+		pushArgs = append(pushArgs, "-p", *archive)
+		v3PushArgs = append(v3PushArgs, "-p", *archive)
+	} else if *path != "" {
+		pushArgs = append(pushArgs, "-p", *path)
+		v3PushArgs = append(v3PushArgs, "-p", *path)
+	}
+	if *noStart {
+		v3PushArgs = append(v3PushArgs, "--no-start")
+	}
+	for _, command := range [][]string{pushArgs, {"cf", "bind-service", app, service, "-c", *paramsFile}, v3PushArgs} {
+		quoted := make([]string, len(command))
+		for i, arg := range command {
+			quoted[i] = shellQuote(arg)
+		}
+		lines = append(lines, strings.Join(quoted, " "))
+	}
+
+	if c.dryRun {
+		fmt.Fprintf(c.Out, "Would write bind parameters to %s: %s\n", *paramsFile, RedactJSON(jsonString))
+		if *script != "" {
+			fmt.Fprintf(c.Out, "Would write the commands to %s:\n", *script)
+		}
+		fmt.Fprintln(c.Out, strings.Join(lines, "\n"))
+		return nil
+	}
+
+	// The parameters include credentials, so keep them private
+	err = ioutil.WriteFile(*paramsFile, []byte(jsonString+"\n"), 0600)
+	if err == nil {
+		err = os.Chmod(*paramsFile, 0600)
+	}
+	if err != nil {
+		return newCommandErrorf(ExitFailure, "Error writing bind parameters to \"%s\": %s", *paramsFile, err.Error())
+	}
+
+	if *script == "" {
+		fmt.Fprintf(c.Out, "Wrote bind parameters to %s. Run these commands instead of the plugin:\n\n", *paramsFile)
+		fmt.Fprintln(c.Out, strings.Join(lines, "\n"))
+	} else {
+		contents := "#!/bin/sh\nset -e\n\n" + strings.Join(lines, "\n") + "\n"
+		err = ioutil.WriteFile(*script, []byte(contents), 0700)
+		if err != nil {
+			return newCommandErrorf(ExitFailure, "Error writing script \"%s\": %s", *script, err.Error())
+		}
+		fmt.Fprintf(c.Out, "Wrote bind parameters to %s and the commands to %s\n", *paramsFile, *script)
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(c.Out, "\nFill in the placeholders for %s before running the commands\n", strings.Join(missing, ", "))
+	}
+	return nil
+}
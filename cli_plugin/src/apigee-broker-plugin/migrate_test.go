@@ -0,0 +1,125 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApigeeMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	params := filepath.Join(dir, "params.json")
+	script := filepath.Join(dir, "migrate.sh")
+
+	args := append(append([]string{"apigee-migrate"}, coresidentArgs[1:]...), "--bearer", "tok", "--start", "--coresident",
+		"--config", "config", "--buildpack", "go_buildpack", "--params-file", params)
+	out, code := runPlugin(&fakeCliConnection{}, args, "")
+	if code != ExitOK {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+	want := "# Copy config into the application and set APIGEE_MICROGATEWAY_CONFIG_DIR to its path in the manifest env\n" +
+		"cf push myapp --no-start\n" +
+		"cf bind-service myapp mgc-svc -c " + params + "\n" +
+		"cf v3-push myapp -b microgateway_decorator -b go_buildpack\n"
+	if !strings.HasSuffix(out, want) {
+		t.Errorf("output:\n%s\nwant it to end with:\n%s", out, want)
+	}
+	data, err := ioutil.ReadFile(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantParams := `{"org":"myorg","env":"test","action":"proxy bind","target_app_route":"myapp","target_app_port":"8080","edgemicro_key":"key","edgemicro_secret":"secret","bearer":"tok"}` + "\n"
+	if string(data) != wantParams {
+		t.Errorf("parameters %s, want %s", data, wantParams)
+	}
+	if info, err := os.Stat(params); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("parameters file mode %v, %v, want 0600", info.Mode(), err)
+	}
+
+	out, code = runPlugin(&fakeCliConnection{}, []string{"apigee-migrate", "--app", "my app", "--archive", "app.jar",
+		"--no-start", "--params-file", params, "--script", script}, "")
+	if code != ExitOK {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+	if !strings.Contains(out, "Fill in the placeholders for --service, --apigee_org, --apigee_env, --action, --target_app_route, --target_app_port, --edgemicro_key, --edgemicro_secret, --bearer") {
+		t.Errorf("output does not list the placeholders:\n%s", out)
+	}
+	data, err = ioutil.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantScript := "#!/bin/sh\nset -e\n\n" +
+		"# Note: the microgateway_decorator buildpack does not support Java applications\n" +
+		"cf push 'my app' --no-start -p app.jar\n" +
+		"cf bind-service 'my app' '<service>' -c " + params + "\n" +
+		"cf v3-push 'my app' -b microgateway_decorator -b nodejs_buildpack -p app.jar --no-start\n"
+	if string(data) != wantScript {
+		t.Errorf("script:\n%s\nwant:\n%s", data, wantScript)
+	}
+	data, _ = ioutil.ReadFile(params)
+	if !strings.Contains(string(data), `{"org":"<apigee_org>","env":"<apigee_env>","action":"<action>",`) {
+		t.Errorf("parameters %s do not have placeholders", data)
+	}
+
+	// Every apigee-push flag is accepted, so a push command line migrates unchanged
+	out, code = runPlugin(&fakeCliConnection{}, []string{"apigee-migrate", "--app", "myapp", "--path", "app", "--config", "config",
+		"--plugins", "plugins", "--coresident", "--non-interactive", "--exclude", "*.md", "--exclude", "test/", "--symlinks", "follow",
+		"--reproducible", "--archive-prefix", "WEB-INF", "--max-extracted-size", "10", "--max-entries", "10", "--max-ratio", "10",
+		"--verify-only", "--params-file", params}, "")
+	if code != ExitOK {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+	for _, line := range []string{
+		"# Leave files matching *.md, test/ out of the copies, or list them in .cfignore\n",
+		"# Note: --archive-prefix, --max-entries, --max-extracted-size, --max-ratio, --reproducible, --symlinks, --verify-only only apply to archives decorated by apigee-push and are left out\n",
+		"cf push myapp --no-start -p app\n",
+		"cf v3-push myapp -b microgateway_decorator -b nodejs_buildpack -p app\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("output:\n%s\ndoes not contain:\n%s", out, line)
+		}
+	}
+
+	out, code = runPlugin(&fakeCliConnection{}, []string{"apigee-migrate", "--archive", "app.jar", "--path", "app"}, "")
+	if code != ExitUsage || !strings.Contains(out, "--archive and --path cannot be used together") {
+		t.Errorf("exit code %d\noutput: %s", code, out)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"myapp":              "myapp",
+		"/tmp/params.json":   "/tmp/params.json",
+		"my app":             "'my app'",
+		"it's":               `'it'\''s'`,
+		"$(rm -rf /)":        "'$(rm -rf /)'",
+		"<edgemicro_secret>": "'<edgemicro_secret>'",
+	}
+	for arg, want := range tests {
+		if quoted := shellQuote(arg); quoted != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", arg, quoted, want)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+//Profile holds named defaults for the flags that rarely change within a cf space
+type Profile struct {
+	Org      string `json:"apigee_org,omitempty"`
+	Env      string `json:"apigee_env,omitempty"`
+	Service  string `json:"service,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+//PluginConfig is the plugin's configuration file, stored under the cf home directory
+type PluginConfig struct {
+	ActiveProfile string             `json:"active_profile,omitempty"`
+	Profiles      map[string]Profile `json:"profiles"`
+}
+
+//profileFlags are the flag names a profile can provide defaults for, in the order they are shown
+var profileFlags = []string{"apigee_org", "apigee_env", "service", "domain", "protocol"}
+
+//Values returns the profile's defaults keyed by the flag name they fill in
+func (p Profile) Values() map[string]string {
+	return map[string]string{
+		"apigee_org": p.Org,
+		"apigee_env": p.Env,
+		"service":    p.Service,
+		"domain":     p.Domain,
+		"protocol":   p.Protocol,
+	}
+}
+
+//CfHomeDir returns the directory the cf cli keeps its .cf folder in, honouring CF_HOME like the cf cli does
+func CfHomeDir() string {
+	if home := os.Getenv("CF_HOME"); home != "" {
+		return home
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return home
+	}
+	return os.Getenv("USERPROFILE")
+}
+
+//pluginDir is the directory the plugin keeps its files in
+func (c *ApigeeBrokerPlugin) pluginDir() (string, error) {
+	if c.CfHome == "" {
+		return "", newCommandErrorf(ExitFailure, "Error: Could not determine the cf home directory, set CF_HOME")
+	}
+	return filepath.Join(c.CfHome, ".cf", "apigee-broker-plugin"), nil
+}
+
+//LoadConfig reads the plugin configuration file, returning an empty configuration if there is none yet
+func (c *ApigeeBrokerPlugin) LoadConfig() (*PluginConfig, error) {
+	config := &PluginConfig{Profiles: map[string]Profile{}}
+	dir, err := c.pluginDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, newCommandErrorf(ExitFailure, "Error reading plugin configuration: %s", err.Error())
+	}
+	err = json.Unmarshal(data, config)
+	if err != nil {
+		return nil, newCommandErrorf(ExitFailure, "Error parsing plugin configuration \"%s\": %s", filepath.Join(dir, "config.json"), err.Error())
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]Profile{}
+	}
+	return config, nil
+}
+
+//SaveConfig writes the plugin configuration file, readable only by the current user
+func (c *ApigeeBrokerPlugin) SaveConfig(config *PluginConfig) error {
+	dir, err := c.pluginDir()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return newCommandErrorf(ExitFailure, "Error making directory \"%s\": %s", dir, err.Error())
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return newCommandErrorf(ExitFailure, "Error encoding plugin configuration: %s", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "config.json"), data, 0600)
+	if err != nil {
+		return newCommandErrorf(ExitFailure, "Error writing plugin configuration: %s", err.Error())
+	}
+	return nil
+}
+
+//ApplyProfile fills in any flag in generalConfig that was not set on the command line from the named profile, or
+//the active profile when name is empty. Explicit flags always win
+func (c *ApigeeBrokerPlugin) ApplyProfile(name string, generalConfig map[string]UserInput, flags *flag.FlagSet) error {
+	if name == "" && c.CfHome == "" {
+		return nil
+	}
+	config, err := c.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = config.ActiveProfile
+		if name == "" {
+			return nil
+		}
+	}
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return newCommandErrorf(ExitUsage, "Error: Profile \"%s\" does not exist", name)
+	}
+
+	setFlags := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+	for key, value := range profile.Values() {
+		input, ok := generalConfig[key]
+		if ok && value != "" && !setFlags[key] {
+			*input.value = value
+		}
+	}
+	return nil
+}
+
+//ApigeeProfileCommand manages the named profiles that hold defaults for the bind and unbind commands
+func (c *ApigeeBrokerPlugin) ApigeeProfileCommand(cliConnection plugin.CliConnection, args []string) error {
+	if len(args) < 2 {
+		return newCommandErrorf(ExitUsage, "Error: Missing subcommand, expected one of create, list, show, use or delete")
+	}
+	subcommand := args[1]
+
+	flags := flag.NewFlagSet("apigee-profile "+subcommand, flag.ContinueOnError)
+	flags.SetOutput(c.Out)
+	var profile Profile
+	use, force := new(bool), new(bool)
+	if subcommand == "create" {
+		flags.StringVar(&profile.Org, "apigee_org", "", "Apigee organization")
+		flags.StringVar(&profile.Env, "apigee_env", "", "Apigee environment")
+		flags.StringVar(&profile.Service, "service", "", "Service instance name")
+		flags.StringVar(&profile.Domain, "domain", "", "Domain of applications")
+		flags.StringVar(&profile.Protocol, "protocol", "", "Target application protocol")
+		use = flags.Bool("use", false, "Make this the active profile")
+		force = flags.Bool("force", false, "Replace a profile of the same name")
+	}
+
+	// The profile name may come before or after the flags
+	var name string
+	rest := args[2:]
+	if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		name, rest = rest[0], rest[1:]
+	}
+	err := flags.Parse(rest)
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return newCommandErrorf(ExitUsage, "Error: Couldn't parse arguments: %s", err.Error())
+	}
+	if name == "" && flags.NArg() == 1 {
+		name = flags.Arg(0)
+	} else if flags.NArg() > 0 {
+		return newCommandErrorf(ExitUsage, "Error: Unknown extra arguments")
+	}
+
+	config, err := c.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	switch subcommand {
+	case "create":
+		if name == "" {
+			return newCommandErrorf(ExitMissingInput, "Did not set the required value for \"name\". Exiting")
+		}
+		if _, ok := config.Profiles[name]; ok && !*force {
+			return newCommandErrorf(ExitUsage, "Error: Profile \"%s\" already exists, use --force to replace it", name)
+		}
+		config.Profiles[name] = profile
+		if *use || len(config.Profiles) == 1 {
+			config.ActiveProfile = name
+		}
+		err = c.SaveConfig(config)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.Out, "Saved profile \"%s\"\n", name)
+	case "list":
+		names := make([]string, 0, len(config.Profiles))
+		for profileName := range config.Profiles {
+			names = append(names, profileName)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			fmt.Fprintln(c.Out, "No profiles found. Create one with \"cf apigee-profile create NAME\"")
+		}
+		for _, profileName := range names {
+			marker := " "
+			if profileName == config.ActiveProfile {
+				marker = "*"
+			}
+			fmt.Fprintf(c.Out, "%s %s\n", marker, profileName)
+		}
+	case "show":
+		if name == "" {
+			name = config.ActiveProfile
+		}
+		if name == "" {
+			return newCommandErrorf(ExitMissingInput, "Error: No active profile, give the name of the profile to show")
+		}
+		profile, ok := config.Profiles[name]
+		if !ok {
+			return newCommandErrorf(ExitUsage, "Error: Profile \"%s\" does not exist", name)
+		}
+		fmt.Fprintf(c.Out, "Profile \"%s\"\n", name)
+		values := profile.Values()
+		for _, key := range profileFlags {
+			if values[key] != "" {
+				fmt.Fprintf(c.Out, "  %s: %s\n", key, values[key])
+			}
+		}
+	case "use":
+		if _, ok := config.Profiles[name]; !ok {
+			return newCommandErrorf(ExitUsage, "Error: Profile \"%s\" does not exist", name)
+		}
+		config.ActiveProfile = name
+		err = c.SaveConfig(config)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.Out, "Using profile \"%s\"\n", name)
+	case "delete":
+		if _, ok := config.Profiles[name]; !ok {
+			return newCommandErrorf(ExitUsage, "Error: Profile \"%s\" does not exist", name)
+		}
+		delete(config.Profiles, name)
+		if config.ActiveProfile == name {
+			config.ActiveProfile = ""
+		}
+		err = c.SaveConfig(config)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.Out, "Deleted profile \"%s\"\n", name)
+	default:
+		return newCommandErrorf(ExitUsage, "Error: Unknown subcommand \"%s\", expected one of create, list, show, use or delete", subcommand)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func runPluginWithHome(home string, conn *fakeCliConnection, args ...string) (string, int) {
+	var out bytes.Buffer
+	code := ExitOK
+	c := newTestPlugin("", nil, &out, &code)
+	c.CfHome = home
+	c.Run(conn, args)
+	return out.String(), code
+}
+
+func TestProfileLifecycle(t *testing.T) {
+	home, err := ioutil.TempDir("", "cf_home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	conn := &fakeCliConnection{}
+
+	steps := []struct {
+		args     []string
+		wantOut  string
+		wantCode int
+	}{
+		{args: []string{"apigee-profile", "list"}, wantOut: "No profiles found"},
+		{args: []string{"apigee-profile", "create", "dev", "--apigee_org", "myorg", "--apigee_env", "test", "--service", "org-svc", "--domain", "apps.example.com"}, wantOut: `Saved profile "dev"`},
+		{args: []string{"apigee-profile", "create", "--apigee_org", "prodorg", "--apigee_env", "prod", "prod"}, wantOut: `Saved profile "prod"`},
+		{args: []string{"apigee-profile", "list"}, wantOut: "* dev\n  prod\n"},
+		{args: []string{"apigee-profile", "create", "prod", "--apigee_org", "otherorg"}, wantCode: ExitUsage, wantOut: `Profile "prod" already exists, use --force to replace it`},
+		{args: []string{"apigee-profile", "show", "prod"}, wantOut: "  apigee_org: prodorg\n  apigee_env: prod\n"},
+		{args: []string{"apigee-profile", "create", "prod", "--force", "--apigee_org", "neworg", "--apigee_env", "prod"}, wantOut: `Saved profile "prod"`},
+		{args: []string{"apigee-profile", "show", "prod"}, wantOut: "  apigee_org: neworg\n  apigee_env: prod\n"},
+		{args: []string{"apigee-profile", "show"}, wantOut: "Profile \"dev\"\n  apigee_org: myorg\n  apigee_env: test\n  service: org-svc\n  domain: apps.example.com\n"},
+		{args: []string{"apigee-profile", "use", "prod"}, wantOut: `Using profile "prod"`},
+		{args: []string{"apigee-profile", "list"}, wantOut: "  dev\n* prod\n"},
+		{args: []string{"apigee-profile", "use", "staging"}, wantCode: ExitUsage, wantOut: `Profile "staging" does not exist`},
+		{args: []string{"apigee-profile", "delete", "prod"}, wantOut: `Deleted profile "prod"`},
+		{args: []string{"apigee-profile", "show"}, wantCode: ExitMissingInput, wantOut: "No active profile"},
+		{args: []string{"apigee-profile", "rename", "dev"}, wantCode: ExitUsage, wantOut: `Unknown subcommand "rename"`},
+	}
+	for _, step := range steps {
+		out, code := runPluginWithHome(home, conn, step.args...)
+		if code != step.wantCode || !strings.Contains(out, step.wantOut) {
+			t.Errorf("%v: got code %d and output %q, want code %d and output containing %q", step.args, code, out, step.wantCode, step.wantOut)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(home, ".cf", "apigee-broker-plugin", "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("config file mode %v, want 0600", info.Mode().Perm())
+	}
+	if len(conn.commands) != 0 {
+		t.Errorf("profile commands should not run cf commands, ran %q", conn.commands)
+	}
+}
+
+func TestBindUsesActiveProfile(t *testing.T) {
+	home, err := ioutil.TempDir("", "cf_home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	conn := &fakeCliConnection{}
+	runPluginWithHome(home, conn, "apigee-profile", "create", "dev", "--apigee_org", "myorg", "--apigee_env", "test",
+		"--service", "org-svc", "--domain", "apps.example.com", "--protocol", "https")
+	runPluginWithHome(home, conn, "apigee-profile", "create", "other", "--apigee_org", "otherorg", "--service", "other-svc")
+
+	out, code := runPluginWithHome(home, conn, "apigee-bind-org", "--app", "myapp", "--apigee_env", "prod", "--action", "proxy",
+		"--bearer", "tok", "--non-interactive")
+	if code != ExitOK {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+	out, code = runPluginWithHome(home, conn, "apigee-unbind-mgc", "--app", "myapp", "--profile", "other", "--non-interactive")
+	if code != ExitOK {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+	out, code = runPluginWithHome(home, conn, "apigee-unbind-mgc", "--app", "myapp", "--profile", "missing", "--non-interactive")
+	if code != ExitUsage {
+		t.Errorf("exit code %d for a missing profile, want %d\noutput: %s", code, ExitUsage, out)
+	}
+
+	want := [][]string{
+		{"bind-route-service", "apps.example.com", "org-svc", "--hostname", "myapp", "-c",
+			`{"org":"myorg","env":"prod","action":"proxy","protocol":"https","bearer":"tok"}`},
+		{"unbind-service", "myapp", "other-svc"},
+	}
+	if !reflect.DeepEqual(conn.commands, want) {
+		t.Errorf("cf commands:\n got %q\nwant %q", conn.commands, want)
+	}
+}
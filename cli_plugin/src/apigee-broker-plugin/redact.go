@@ -0,0 +1,281 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//Redacted replaces secrets in the output
+const Redacted = "********"
+
+// values of JSON keys containing one of these are secrets
+var secretKeys = []string{"secret", "pass", "token", "bearer", "basic"}
+
+// secrets shorter than this are only hidden where they stand as a word of their own, since they are likely to be part of
+// ordinary words too
+const shortSecretLen = 8
+
+// output held back waiting for the end of its line is written out anyway once it is this long
+const maxPendingOutput = 64 << 10
+
+// command line flags holding secrets
+var secretFlags = []string{"pass", "bearer", "basic", "edgemicro_secret"}
+
+// match the string values of secret keys in JSON, and in JSON quoted inside another string as in {\"pass\":\"x\"}
+var (
+	secretJSONValue       = regexp.MustCompile(`(?i)("[^"\\]*(?:` + strings.Join(secretKeys, "|") + `)[^"\\]*"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+	quotedSecretJSONValue = regexp.MustCompile(`(?i)(\\"[^"\\]*(?:` + strings.Join(secretKeys, "|") + `)[^"\\]*\\"\s*:\s*)\\"[^"\\]*\\"`)
+)
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+//Redactor hides secrets in the text the plugin prints: the values of secret keys in any JSON, and every secret value
+//it has been told about wherever it appears, also JSON or URL encoded
+type Redactor struct {
+	secrets []string
+	mutex   sync.Mutex
+}
+
+//Add registers secret values to hide. Empty values are ignored
+func (r *Redactor) Add(secrets ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		quoted, _ := json.Marshal(secret)
+		for _, form := range []string{secret, string(quoted[1 : len(quoted)-1]), url.QueryEscape(secret)} {
+			if !contains(r.secrets, form) {
+				r.secrets = append(r.secrets, form)
+			}
+		}
+	}
+	// Longer secrets first, so that a secret containing another is hidden whole
+	sort.SliceStable(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+}
+
+//AddArgs registers the values of the secret flags in a command line
+func (r *Redactor) AddArgs(args []string) {
+	for i, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if eq := strings.Index(name, "="); eq >= 0 {
+			if contains(secretFlags, name[:eq]) {
+				r.Add(name[eq+1:])
+			}
+		} else if contains(secretFlags, name) && i+1 < len(args) {
+			r.Add(args[i+1])
+		}
+	}
+}
+
+//AddInputs registers the values of the secret keys of flag configurations, such as values typed at a prompt
+func (r *Redactor) AddInputs(configs ...map[string]UserInput) {
+	for _, config := range configs {
+		for key, input := range config {
+			if isSecretKey(key) {
+				r.Add(*input.value)
+			}
+		}
+	}
+}
+
+//AddJSON registers the values of the secret keys of a JSON object, such as the parameters given to "cf bind-service -c"
+func (r *Redactor) AddJSON(data string) {
+	var object map[string]interface{}
+	if json.Unmarshal([]byte(data), &object) != nil {
+		return
+	}
+	for key, value := range object {
+		if secret, ok := value.(string); ok && isSecretKey(key) {
+			r.Add(secret)
+		}
+	}
+}
+
+//Redact returns text with every secret hidden
+func (r *Redactor) Redact(text string) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	text = secretJSONValue.ReplaceAllString(text, `${1}"`+Redacted+`"`)
+	text = quotedSecretJSONValue.ReplaceAllString(text, `${1}\"`+Redacted+`\"`)
+	for _, secret := range r.secrets {
+		text = redactValue(text, secret)
+	}
+	return text
+}
+
+//redactValue hides every occurrence of secret in text. Occurrences of a secret shorter than shortSecretLen that are only
+//part of a longer word are left alone, which keeps a secret such as "secret" from garbling "edgemicro_secret"
+func redactValue(text, secret string) string {
+	var buf bytes.Buffer
+	for {
+		i := strings.Index(text, secret)
+		if i < 0 {
+			break
+		}
+		end := i + len(secret)
+		inWord := len(secret) < shortSecretLen &&
+			((i > 0 && isWordByte(text[i-1]) && isWordByte(secret[0])) ||
+				(end < len(text) && isWordByte(text[end]) && isWordByte(secret[len(secret)-1])))
+		if inWord {
+			buf.WriteString(text[:end])
+		} else {
+			buf.WriteString(text[:i])
+			buf.WriteString(Redacted)
+		}
+		text = text[end:]
+	}
+	buf.WriteString(text)
+	return buf.String()
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//Writer returns a writer that redacts everything written through it before passing it on to w. Output is passed on
+//a line at a time, so that a secret written in several pieces is still hidden. The end of a line not yet complete,
+//such as a prompt, is passed on by Flush
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return &redactingWriter{out: w, redactor: r}
+}
+
+// redactingWriter is safe for concurrent use, as the interrupt handler writes to it while a command runs
+type redactingWriter struct {
+	out      io.Writer
+	redactor *Redactor
+	pending  []byte
+	mutex    sync.Mutex
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.pending = append(w.pending, p...)
+	end := bytes.LastIndexByte(w.pending, '\n') + 1
+	if len(w.pending) > maxPendingOutput {
+		end = len(w.pending)
+	}
+	if end == 0 {
+		return len(p), nil
+	}
+	err := w.writeOut(end)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+//Flush redacts and passes on the output held back waiting for the end of its line
+func (w *redactingWriter) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.writeOut(len(w.pending))
+}
+
+func (w *redactingWriter) writeOut(end int) error {
+	if end == 0 {
+		return nil
+	}
+	text := string(w.pending[:end])
+	w.pending = append(w.pending[:0], w.pending[end:]...)
+	_, err := io.WriteString(w.out, w.redactor.Redact(text))
+	return err
+}
+
+//RedactJSON hides the values of secret keys in a JSON object, keeping the order of the keys. Anything that is not a
+//JSON object is returned unchanged
+func RedactJSON(data string) string {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil || token != json.Delim('{') {
+		return data
+	}
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return data
+		}
+		key, ok := token.(string)
+		if !ok {
+			return data
+		}
+		var value json.RawMessage
+		err = decoder.Decode(&value)
+		if err != nil {
+			return data
+		}
+		if buf.Len() > 1 {
+			buf.WriteString(",")
+		}
+		encodedKey, _ := json.Marshal(key)
+		buf.Write(encodedKey)
+		buf.WriteString(":")
+		if isSecretKey(key) {
+			buf.WriteString(`"` + Redacted + `"`)
+		} else {
+			buf.Write(value)
+		}
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+//RedactArgs returns cf arguments with the secrets in "-c" parameters hidden
+func RedactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		if i > 0 && args[i-1] == "-c" {
+			arg = RedactJSON(arg)
+		}
+		redacted[i] = arg
+	}
+	return redacted
+}
@@ -0,0 +1,240 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets []string
+		text    string
+		want    string
+	}{
+		{
+			name:    "known value",
+			secrets: []string{"p@ss w0rd"},
+			text:    "Error: password p@ss w0rd was rejected",
+			want:    "Error: password ******** was rejected",
+		},
+		{
+			name:    "JSON and URL encoded values",
+			secrets: []string{`p"ss w0rd`},
+			text:    `{"password":"p\"ss w0rd"} password=p%22ss+w0rd`,
+			want:    `{"password":"********"} password=********`,
+		},
+		{
+			name:    "value inside a longer word",
+			secrets: []string{"secret"},
+			text:    "--edgemicro_secret secret",
+			want:    "--edgemicro_secret ********",
+		},
+		{
+			name:    "value glued to other text",
+			secrets: []string{"s3cr3t-t0ken"},
+			text:    "token=s3cr3t-t0kenabc https://api.example.com/v1?access_token=s3cr3t-t0ken&x=1 Xs3cr3t-t0ken",
+			want:    "token=********abc https://api.example.com/v1?access_token=********&x=1 X********",
+		},
+		{
+			name:    "longer secret containing another",
+			secrets: []string{"abcd", "abcd-efgh"},
+			text:    "abcd-efgh abcd",
+			want:    "******** ********",
+		},
+		{
+			name: "secret keys of unknown JSON",
+			text: `{"org":"myorg","access_token":"abc","Pass" : "x\"y"}`,
+			want: `{"org":"myorg","access_token":"********","Pass" : "********"}`,
+		},
+		{
+			name: "JSON quoted inside a string",
+			text: `Error: "-c" "{\"org\":\"myorg\",\"bearer\":\"abc\"}"`,
+			want: `Error: "-c" "{\"org\":\"myorg\",\"bearer\":\"********\"}"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var r Redactor
+			r.Add(test.secrets...)
+			if redacted := r.Redact(test.text); redacted != test.want {
+				t.Errorf("Redact(%q) = %q, want %q", test.text, redacted, test.want)
+			}
+		})
+	}
+}
+
+func TestRedactorAddArgs(t *testing.T) {
+	var r Redactor
+	r.AddArgs([]string{"apigee-bind", "--pass", "pass-1", "-bearer=bearer-1", "--edgemicro_secret", "secret-1",
+		"--bearer-file", "/tmp/bearer", "--app", "myapp"})
+	text := "pass-1 bearer-1 secret-1 /tmp/bearer myapp"
+	want := "******** ******** ******** /tmp/bearer myapp"
+	if redacted := r.Redact(text); redacted != want {
+		t.Errorf("Redact(%q) = %q, want %q", text, redacted, want)
+	}
+}
+
+func TestRedactingWriter(t *testing.T) {
+	var r Redactor
+	r.Add("s3cr3t-t0ken")
+	var out bytes.Buffer
+	w := r.Writer(&out)
+
+	// A secret split across writes is only passed on once its line is complete
+	fmt.Fprint(w, "token s3cr")
+	fmt.Fprint(w, "3t-t0ken rejected\nPassword: s3cr3t")
+	if got, want := out.String(), "token ******** rejected\n"; got != want {
+		t.Errorf("output %q, want %q", got, want)
+	}
+	fmt.Fprint(w, "-t0ken\n")
+	if got, want := out.String(), "token ******** rejected\nPassword: ********\n"; got != want {
+		t.Errorf("output %q, want %q", got, want)
+	}
+
+	// Flush passes on a prompt without a newline
+	fmt.Fprint(w, "Service instance name [required]: ")
+	if err := w.(interface{ Flush() error }).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), "Service instance name [required]: ") {
+		t.Errorf("output %q does not end with the prompt", out.String())
+	}
+}
+
+// captureStderr runs f with os.Stderr redirected, returning what was written to it
+func captureStderr(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	f()
+	os.Stderr = stderr
+	w.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestNoSecretsInOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bearerFile := filepath.Join(dir, "bearer")
+	writeTestFiles(t, dir, map[string]string{"bearer": "file-token.xyz\n"})
+
+	// The token endpoint echoes the password back in its error, as a misbehaving proxy might
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(w, `{"error_description":"Bad credentials %s:%s"}`, r.PostForm.Get("username"), r.PostForm.Get("password"))
+	}))
+	defer tokenServer.Close()
+
+	bindArgs := []string{"--service", "org-svc", "--app", "myapp", "--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy"}
+	tests := []struct {
+		name      string
+		args      []string
+		stdin     string
+		passwords []string
+		env       map[string]string
+		errs      map[string]error
+		secrets   []string
+	}{
+		{
+			name: "bind-service error echoing the parameters",
+			args: []string{"apigee-bind-mgc", "--app", "myapp", "--service", "mgc-svc", "--apigee_org", "myorg", "--apigee_env", "test",
+				"--edgemicro_key", "key", "--edgemicro_secret", "mg-secret-1", "--target_app_route", "myapp", "--target_app_port", "8080",
+				"--action", "proxy bind", "--bearer", "bearer.token.1"},
+			errs: map[string]error{"bind-service": errors.New(`Server error, status code: 502, message: Invalid parameters ` +
+				`{"org":"myorg","edgemicro_secret":"mg-secret-1","bearer":"bearer.token.1"} for secret mg-secret-1`)},
+			secrets: []string{"mg-secret-1", "bearer.token.1"},
+		},
+		{
+			name: "token from a file echoed inside quoted JSON",
+			args: append([]string{"apigee-bind-org", "--bearer-file", bearerFile}, bindArgs...),
+			errs: map[string]error{"bind-route-service": errors.New(`Error: "-c" "{\"bearer\":\"file-token.xyz\"}" rejected, ` +
+				`token file-token.xyz is invalid`)},
+			secrets: []string{"file-token.xyz"},
+		},
+		{
+			name:      "password typed at the prompt",
+			args:      append([]string{"apigee-bind-org"}, bindArgs...),
+			stdin:     "n\nme@example.com\n",
+			passwords: []string{"typed p@ss"},
+			errs:      map[string]error{"bind-route-service": errors.New("Login failed for me@example.com with password typed p@ss")},
+			secrets:   []string{"typed p@ss"},
+		},
+		{
+			name:    "password from the environment",
+			args:    append([]string{"apigee-bind-org", "--user", "me@example.com"}, bindArgs...),
+			env:     map[string]string{"APIGEE_PASSWORD": "env-pass-1"},
+			errs:    map[string]error{"bind-route-service": errors.New("Login failed: user=me%40example.com&password=env-pass-1")},
+			secrets: []string{"env-pass-1"},
+		},
+		{
+			name:    "missing value with a password flag",
+			args:    []string{"apigee-bind-org", "--service", "org-svc", "--app", "myapp", "--pass=flag-pass-1", "--user", "me"},
+			secrets: []string{"flag-pass-1"},
+		},
+		{
+			name:    "login error echoing the password",
+			args:    []string{"apigee-login", "--user", "me@example.com", "--pass", "login-pass-1", "--token_url", tokenServer.URL},
+			secrets: []string{"login-pass-1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			code := ExitOK
+			c := newTestPlugin(test.stdin, test.passwords, &out, &code)
+			c.Getenv = func(key string) string { return test.env[key] }
+			stderr := captureStderr(t, func() {
+				c.Run(&fakeCliConnection{errs: test.errs}, test.args)
+			})
+			if code == ExitOK {
+				t.Fatalf("command succeeded, want a failure\noutput: %s", out.String())
+			}
+			for _, secret := range test.secrets {
+				if strings.Contains(out.String(), secret) || strings.Contains(stderr, secret) {
+					t.Errorf("secret %q printed\nstdout: %s\nstderr: %s", secret, out.String(), stderr)
+				}
+			}
+			if test.errs != nil && !strings.Contains(out.String(), Redacted) {
+				t.Errorf("output %q does not contain the redacted error", out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//ReproducibleEpoch is the modification time reproducible archives give their entries, unless SOURCE_DATE_EPOCH
+//says otherwise. It is the earliest time zip archives can hold
+var ReproducibleEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// zip extra fields recording times or owners, which normalized headers drop
+var volatileExtras = map[uint16]bool{
+	0x5455: true, // extended timestamp
+	0x5855: true, // Info-ZIP Unix, times and owner
+	0x7855: true, // Info-ZIP Unix, owner
+	0x7875: true, // Info-ZIP Unix, owner
+	0x000d: true, // PKWARE Unix, times and owner
+}
+
+//inCI reports whether the plugin runs in a continuous integration build, which sets CI as most CI services do
+func (c *ApigeeBrokerPlugin) inCI() bool {
+	ci, err := strconv.ParseBool(c.getenv("CI"))
+	return err == nil && ci
+}
+
+//reproducibleModTime returns the time given by SOURCE_DATE_EPOCH, in seconds since the Unix epoch, or
+//ReproducibleEpoch when it is not set
+func (c *ApigeeBrokerPlugin) reproducibleModTime() (time.Time, error) {
+	epoch := c.getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return ReproducibleEpoch, nil
+	}
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil || seconds < ReproducibleEpoch.Unix() {
+		return time.Time{}, newCommandErrorf(ExitUsage, "Error: SOURCE_DATE_EPOCH \"%s\" is not a number of seconds since 1980", epoch)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+//normalizeHeader gives an archive entry modTime and drops the extra fields recording times and owners, leaving
+//everything else as it is. The MS-DOS time fields are set too, since zip.Writer.CreateRaw writes them as they are
+func normalizeHeader(header *zip.FileHeader, modTime time.Time) {
+	header.Modified = modTime
+	header.ModifiedDate = uint16((modTime.Year()-1980)<<9 | int(modTime.Month())<<5 | modTime.Day())
+	header.ModifiedTime = uint16(modTime.Hour()<<11 | modTime.Minute()<<5 | modTime.Second()/2)
+	header.Extra = dropExtras(header.Extra)
+}
+
+//normalizeMode gives an added file one of the modes a version control checkout would, whatever the umask
+func normalizeMode(header *zip.FileHeader) {
+	mode := header.Mode()
+	switch {
+	case mode.IsDir():
+		header.SetMode(os.ModeDir | 0755)
+	case mode&os.ModeSymlink != 0:
+		header.SetMode(os.ModeSymlink | 0777)
+	case mode&0111 != 0:
+		header.SetMode(0755)
+	default:
+		header.SetMode(0644)
+	}
+}
+
+//dropExtras returns the extra fields of an entry without the volatile ones. Malformed fields are dropped too
+func dropExtras(extra []byte) []byte {
+	kept := make([]byte, 0, len(extra))
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		if !volatileExtras[tag] {
+			kept = append(kept, extra[:4+size]...)
+		}
+		extra = extra[4+size:]
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return kept
+}
+
+//sortEntries orders the entries of an archive by name, apart from the manifest which stays first
+func sortEntries(files []*zip.File) []*zip.File {
+	sorted := append([]*zip.File{}, files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return manifestFirst(sorted)
+}
+
+//pinCompression makes the archive deflate at a fixed level, rather than whatever the zip package defaults to
+func pinCompression(archiveWriter *zip.Writer) {
+	archiveWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.DefaultCompression)
+	})
+}
+
+//FileSHA256 returns the hex encoded SHA-256 digest of a file's contents
+func FileSHA256(fpath string) (string, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error reading in file \"%s\": %s", fpath, err.Error())
+		return "", errors.New(errorMsg)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error reading in file \"%s\": %s", fpath, err.Error())
+		return "", errors.New(errorMsg)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDropExtras(t *testing.T) {
+	tests := []struct {
+		name  string
+		extra []byte
+		want  []byte
+	}{
+		{name: "none"},
+		{name: "jar marker kept", extra: []byte{0xfe, 0xca, 0, 0}, want: []byte{0xfe, 0xca, 0, 0}},
+		{name: "extended timestamp dropped", extra: []byte{0x55, 0x54, 5, 0, 1, 1, 2, 3, 4}},
+		{
+			name:  "owner dropped between kept fields",
+			extra: []byte{0xfe, 0xca, 0, 0, 0x75, 0x78, 3, 0, 1, 0, 0, 0x34, 0x12, 1, 0, 9},
+			want:  []byte{0xfe, 0xca, 0, 0, 0x34, 0x12, 1, 0, 9},
+		},
+		{name: "truncated field dropped", extra: []byte{0xfe, 0xca, 0, 0, 0x34, 0x12, 9, 0, 1}, want: []byte{0xfe, 0xca, 0, 0}},
+	}
+	for _, test := range tests {
+		if got := dropExtras(test.extra); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: dropExtras(%v) = %v, want %v", test.name, test.extra, got, test.want)
+		}
+	}
+}
+
+// reproducibleInputs writes an archive whose entries are out of order and carry times, and config and plugins
+// directories, touching the directories with modTime and mode
+func reproducibleInputs(t *testing.T, dir string, modTime time.Time, mode os.FileMode) (string, string, string) {
+	archive := filepath.Join(dir, "app.jar")
+	if _, err := os.Stat(archive); os.IsNotExist(err) {
+		entries := []craftedEntry{
+			{name: "app/Main.class", body: "class"},
+			{name: "META-INF/MANIFEST.MF", body: "Main-Class: app.Main\n"},
+			{name: "app/Helper.class", body: "helper"},
+		}
+		writeCraftedZip(t, archive, entries)
+	}
+	config := filepath.Join(dir, "config")
+	plugins := filepath.Join(dir, "plugins")
+	writeTestFiles(t, config, map[string]string{"myorg-test-config.yaml": "edge_config: {}\n", "b.yaml": "b\n"})
+	writeTestFiles(t, plugins, map[string]string{"custom/index.js": "module.exports = {}\n"})
+	for _, fpath := range []string{filepath.Join(config, "myorg-test-config.yaml"), filepath.Join(config, "b.yaml"), filepath.Join(plugins, "custom", "index.js")} {
+		if err := os.Chmod(fpath, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fpath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return archive, config, plugins
+}
+
+var shaLine = regexp.MustCompile(`SHA-256 of .*apigee_app\.jar: ([0-9a-f]{64})`)
+
+func TestPushReproducible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push_reproducible")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	decorated := filepath.Join(dir, "apigee_app.jar")
+
+	push := func(modTime time.Time, mode os.FileMode, extra ...string) ([]byte, string) {
+		archive, config, plugins := reproducibleInputs(t, dir, modTime, mode)
+		args := []string{"apigee-push", "--app", "myapp", "--archive", archive, "--config", config, "--plugins", plugins, "--coresident", "--non-interactive"}
+		out, code := runPlugin(&fakeCliConnection{}, append(args, extra...), "")
+		if code != 0 {
+			t.Fatalf("exit code %d\noutput: %s", code, out)
+		}
+		data, err := ioutil.ReadFile(decorated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data, out
+	}
+
+	first, out := push(time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC), 0644, "--reproducible")
+	second, _ := push(time.Date(2018, 6, 7, 8, 9, 10, 0, time.UTC), 0664, "--reproducible")
+	if !bytes.Equal(first, second) {
+		t.Errorf("reproducible archives differ")
+	}
+	match := shaLine.FindStringSubmatch(out)
+	sum, err := FileSHA256(decorated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match == nil || match[1] != sum {
+		t.Errorf("output %q does not give the SHA-256 %s", out, sum)
+	}
+
+	var names []string
+	for _, f := range zipEntries(t, decorated) {
+		names = append(names, f.Name)
+		if !f.Modified.Equal(ReproducibleEpoch) {
+			t.Errorf("%s was modified at %v, want %v", f.Name, f.Modified, ReproducibleEpoch)
+		}
+		if strings.HasPrefix(f.Name, "config/") && !f.Mode().IsDir() && f.Mode().Perm() != 0644 {
+			t.Errorf("%s has mode %v, want 0644", f.Name, f.Mode().Perm())
+		}
+	}
+	want := []string{"META-INF/MANIFEST.MF", "app/Helper.class", "app/Main.class",
+		"config/", "config/b.yaml", "config/myorg-test-config.yaml", "plugins/", "plugins/custom/", "plugins/custom/index.js"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("entries:\n got %q\nwant %q", names, want)
+	}
+
+	// Without --reproducible the times of the files added show through
+	third, out := push(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), 0644, "--reproducible=false")
+	if bytes.Equal(first, third) {
+		t.Errorf("archive is the same without --reproducible")
+	}
+	if strings.Contains(out, "SHA-256") {
+		t.Errorf("output %q gives a SHA-256 without --reproducible", out)
+	}
+}
+
+func TestPushReproducibleSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push_reproducible_env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive, config, _ := reproducibleInputs(t, dir, time.Now(), 0644)
+	args := []string{"apigee-push", "--app", "myapp", "--archive", archive, "--config", config, "--coresident", "--non-interactive"}
+
+	tests := []struct {
+		name        string
+		ci          string
+		epoch       string
+		extra       []string
+		wantCode    int
+		wantOut     string
+		wantSHA     bool
+		wantModTime time.Time
+	}{
+		{name: "on in CI", ci: "true", wantSHA: true, wantModTime: ReproducibleEpoch},
+		{name: "off outside of CI", ci: "", wantSHA: false},
+		{name: "off in CI when asked", ci: "1", extra: []string{"--reproducible=false"}, wantSHA: false},
+		{name: "CI set to false", ci: "false", wantSHA: false},
+		{name: "SOURCE_DATE_EPOCH", epoch: "1500000000", extra: []string{"--reproducible"}, wantSHA: true, wantModTime: time.Unix(1500000000, 0)},
+		{name: "bad SOURCE_DATE_EPOCH", epoch: "yesterday", extra: []string{"--reproducible"}, wantCode: ExitUsage, wantOut: "SOURCE_DATE_EPOCH \"yesterday\""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			code := ExitOK
+			c := newTestPlugin("", nil, &buf, &code)
+			env := map[string]string{"CI": test.ci, "SOURCE_DATE_EPOCH": test.epoch}
+			c.Getenv = func(key string) string { return env[key] }
+			c.Run(&fakeCliConnection{}, append(append([]string{}, args...), test.extra...))
+			out := buf.String()
+			if code != test.wantCode || !strings.Contains(out, test.wantOut) {
+				t.Fatalf("exit code %d, want %d\noutput %q does not contain %q", code, test.wantCode, out, test.wantOut)
+			}
+			if code != 0 {
+				return
+			}
+			if gotSHA := shaLine.MatchString(out); gotSHA != test.wantSHA {
+				t.Errorf("output %q gives a SHA-256: %v, want %v", out, gotSHA, test.wantSHA)
+			}
+			if test.wantModTime.IsZero() {
+				return
+			}
+			for _, f := range zipEntries(t, filepath.Join(dir, "apigee_app.jar")) {
+				if !f.Modified.Equal(test.wantModTime) {
+					t.Errorf("%s was modified at %v, want %v", f.Name, f.Modified, test.wantModTime)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// entryNameRoot is a made-up directory that entry names and link targets are resolved against, only to check them
+// lexically. Nothing is ever written to it
+const entryNameRoot = "entry-name-root"
+
+//dirEntry is a file, directory or symbolic link to add to an archive
+type dirEntry struct {
+	name  string
+	fpath string
+	info  os.FileInfo
+}
+
+//archiveDirEntries lists what adding dirs to an archive adds, each directory under its own name at the archive's
+//root, applying policy to the symbolic links in them. What DirIgnoreList leaves out of each directory, with the exclude
+//patterns, is counted in the returned summary instead. Names use slashes and directories have no trailing slash. It
+//stops with ctx's error once ctx is cancelled
+func archiveDirEntries(ctx context.Context, dirs []string, policy SymlinkPolicy, exclude []string) ([]dirEntry, SkipSummary, error) {
+	entries := make([]dirEntry, 0)
+	var skipped SkipSummary
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		ignore, err := DirIgnoreList(dir, exclude)
+		if err != nil {
+			return nil, skipped, err
+		}
+		base := filepath.Base(dir)
+		err = walkDir(ctx, dir, policy, func(name, fpath string, info os.FileInfo) error {
+			if name != "." && ignore.Ignored(filepath.ToSlash(name), info.IsDir()) {
+				skipped.add(fpath, info)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			entries = append(entries, dirEntry{name: filepath.ToSlash(filepath.Join(base, name)), fpath: fpath, info: info})
+			return nil
+		})
+		if err != nil {
+			return nil, skipped, err
+		}
+	}
+	return entries, skipped, nil
+}
+
+//zipName is the name an entry is stored under in an archive
+func (e dirEntry) zipName() string {
+	if e.info.IsDir() {
+		return e.name + "/"
+	}
+	return e.name
+}
+
+//checkEntry refuses an archive entry that would be extracted outside of the application's directory, through its
+//name or as a symbolic link
+func checkEntry(f *zip.File) error {
+	fpath, err := extractPath(entryNameRoot, f.Name)
+	if err != nil {
+		return err
+	}
+	if f.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error reading in file from archive \"%s\": %s", f.Name, err.Error())
+		return errors.New(errorMsg)
+	}
+	defer rc.Close()
+	target, err := ioutil.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error reading in file from archive \"%s\": %s", f.Name, err.Error())
+		return errors.New(errorMsg)
+	}
+	link := filepath.FromSlash(string(target))
+	if filepath.IsAbs(link) || filepath.VolumeName(link) != "" || !withinDir(entryNameRoot, filepath.Join(filepath.Dir(fpath), link)) {
+		return escapingLinkError(f.Name, string(target))
+	}
+	return nil
+}
+
+func escapingLinkError(name, target string) error {
+	errorMsg := fmt.Sprintf("Error: Archive entry \"%s\" is a symbolic link to \"%s\", outside of the archive's directory", name, target)
+	return errors.New(errorMsg)
+}
+
+//copyRaw copies an entry from one archive to another as it is, without decompressing it. fixHeader, when not nil,
+//may change the entry's metadata
+func copyRaw(ctx context.Context, archiveWriter *zip.Writer, f *zip.File, fixHeader func(*zip.FileHeader)) error {
+	header := f.FileHeader
+	if fixHeader != nil {
+		fixHeader(&header)
+	}
+	writer, err := archiveWriter.CreateRaw(&header)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error adding filemetadata to archive: %s", err.Error())
+		return errors.New(errorMsg)
+	}
+	raw, err := f.OpenRaw()
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error reading in file from archive \"%s\": %s", f.Name, err.Error())
+		return errors.New(errorMsg)
+	}
+	_, err = copyContext(ctx, writer, raw)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error copying \"%s\" to new archive: %s", f.Name, err.Error())
+		return errors.New(errorMsg)
+	}
+	return nil
+}
+
+//RewriteOptions are what RewriteArchive adds to an archive and the limits the archive must keep to
+type RewriteOptions struct {
+	// Dirs are added under their own names
+	Dirs []string
+	// Prefix is the directory in the archive, ending in a slash, the dirs are added under. "" adds them at the root
+	Prefix string
+	// Symlinks is what to do with symbolic links in the dirs
+	Symlinks SymlinkPolicy
+	// Exclude are ignore patterns for files of the dirs to leave out, besides those DirIgnoreList always leaves out
+	Exclude []string
+	Limits  ExtractLimits
+	// Reproducible sorts the entries, gives them all ModTime, or ReproducibleEpoch when it is zero, drops the owners
+	// and times recorded in extra fields, normalizes the modes of the added files and pins the compression level, so
+	// that the same inputs give the same archive byte for byte
+	Reproducible bool
+	ModTime      time.Time
+}
+
+//RewriteArchive writes a copy of archive to dest with the contents of options.Dirs added, in one pass. The entries of
+//the original archive are copied as they are, without being compressed again, apart from the files the directories
+//replace, and the directories are added at the end. The manifest is moved first if it is not already, so jar
+//launchers find it. Archives breaking limits, by the sizes they record or by what their entries really hold once
+//decompressed, or with entries that would be extracted outside of the application's directory, are refused. It
+//returns what options.Exclude and the ignore files of the directories left out, and stops with ctx's error once ctx
+//is cancelled
+func RewriteArchive(ctx context.Context, archive, dest string, options RewriteOptions) (SkipSummary, error) {
+	var skipped SkipSummary
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return skipped, err
+	}
+	defer r.Close()
+
+	err = checkLimits(archive, r.File, options.Limits)
+	if err != nil {
+		return skipped, err
+	}
+	additions, skipped, err := archiveDirEntries(ctx, options.Dirs, options.Symlinks, options.Exclude)
+	if err != nil {
+		return skipped, err
+	}
+	replaced := make(map[string]bool)
+	for _, entry := range additions {
+		replaced[options.Prefix+entry.zipName()] = true
+	}
+
+	target, err := os.Create(dest)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error making new archive \"%s\": %s", dest, err.Error())
+		return skipped, errors.New(errorMsg)
+	}
+	defer target.Close()
+	archiveWriter := zip.NewWriter(target)
+	files := manifestFirst(r.File)
+	var fixOriginal, fixAdded func(*zip.FileHeader)
+	if options.Reproducible {
+		modTime := options.ModTime
+		if modTime.IsZero() {
+			modTime = ReproducibleEpoch
+		}
+		files = sortEntries(r.File)
+		fixOriginal = func(header *zip.FileHeader) {
+			normalizeHeader(header, modTime)
+		}
+		fixAdded = func(header *zip.FileHeader) {
+			normalizeHeader(header, modTime)
+			normalizeMode(header)
+		}
+		pinCompression(archiveWriter)
+	}
+
+	// Sizes recorded in the archive can lie, so what the entries copied extract to is counted too
+	extracted := int64(0)
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return skipped, err
+		}
+		err = checkEntry(f)
+		if err != nil {
+			return skipped, err
+		}
+		if replaced[f.Name] {
+			continue
+		}
+		err = checkSize(ctx, archive, f, options.Limits, &extracted)
+		if err != nil {
+			return skipped, err
+		}
+		err = copyRaw(ctx, archiveWriter, f, fixOriginal)
+		if err != nil {
+			return skipped, err
+		}
+	}
+	for _, entry := range additions {
+		if err := ctx.Err(); err != nil {
+			return skipped, err
+		}
+		err = addEntry(ctx, archiveWriter, options.Prefix+entry.name, entry.fpath, entry.info, fixAdded)
+		if err != nil {
+			return skipped, err
+		}
+	}
+
+	err = archiveWriter.Close()
+	if err == nil {
+		err = target.Close()
+	}
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error writing new archive \"%s\": %s", dest, err.Error())
+		return skipped, errors.New(errorMsg)
+	}
+	return skipped, nil
+}
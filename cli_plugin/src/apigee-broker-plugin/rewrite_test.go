@@ -0,0 +1,355 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func zipEntries(t testing.TB, archive string) []*zip.File {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The headers stay usable once the reader is closed, the contents are read with readZipEntry
+	r.Close()
+	return r.File
+}
+
+func readZipEntry(t testing.TB, archive, name string) string {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Name == name {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			data, err := ioutil.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(data)
+		}
+	}
+	t.Fatalf("%s has no entry %s", archive, name)
+	return ""
+}
+
+func TestRewriteArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rewrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "app.jar")
+	writeTestZip(t, archive, map[string]string{
+		"META-INF/MANIFEST.MF": "Manifest-Version: 1.0\n",
+		"app/Main.class":       strings.Repeat("class ", 10000),
+		"config/old.yaml":      "old",
+		"config/kept.yaml":     "kept",
+	})
+	config := filepath.Join(dir, "config")
+	writeTestFiles(t, config, map[string]string{"myorg-test-config.yaml": "edge_config: {}\n", "old.yaml": "new"})
+	plugins := filepath.Join(dir, "plugins")
+	writeTestFiles(t, plugins, map[string]string{"spikearrest/index.js": "module.exports = {}\n"})
+	dest := filepath.Join(dir, "apigee_app.jar")
+
+	_, err = RewriteArchive(context.Background(), archive, dest, RewriteOptions{Dirs: []string{config, plugins}, Limits: DefaultExtractLimits})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := make(map[string]*zip.File)
+	var originalNames []string
+	for _, f := range zipEntries(t, archive) {
+		original[f.Name] = f
+		if f.Name != "config/" && f.Name != "config/old.yaml" {
+			originalNames = append(originalNames, f.Name)
+		}
+	}
+	var names []string
+	for _, f := range zipEntries(t, dest) {
+		names = append(names, f.Name)
+		if o, ok := original[f.Name]; ok && f.Name != "config/old.yaml" {
+			if f.Method != o.Method || f.CompressedSize64 != o.CompressedSize64 || f.CRC32 != o.CRC32 {
+				t.Errorf("%s was not copied as it was", f.Name)
+			}
+		}
+	}
+	want := append(originalNames, "config/", "config/myorg-test-config.yaml", "config/old.yaml",
+		"plugins/", "plugins/spikearrest/", "plugins/spikearrest/index.js")
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("entries:\n got %q\nwant %q", names, want)
+	}
+	for name, contents := range map[string]string{"config/old.yaml": "new", "config/kept.yaml": "kept", "app/Main.class": strings.Repeat("class ", 10000)} {
+		if got := readZipEntry(t, dest, name); got != contents {
+			t.Errorf("%s = %.20q, want %.20q", name, got, contents)
+		}
+	}
+}
+
+func TestRewriteArchiveRefusesUnsafeArchives(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []craftedEntry
+		// lying, when set, is the contents of a single entry recording a size of 10 bytes
+		lying   []byte
+		limits  ExtractLimits
+		wantErr string
+	}{
+		{
+			name:    "parent directory",
+			entries: []craftedEntry{{name: "ok"}, {name: "lib/../../evil", body: "evil"}},
+			wantErr: `Archive entry "lib/../../evil" would be extracted outside of the archive's directory`,
+		},
+		{
+			name:    "absolute path",
+			entries: []craftedEntry{{name: "/tmp/evil", body: "evil"}},
+			wantErr: "has an absolute path",
+		},
+		{
+			name:    "backslash absolute path",
+			entries: []craftedEntry{{name: `\evil`, body: "evil"}},
+			wantErr: "has an absolute path",
+		},
+		{
+			name:    "symbolic link to a parent directory",
+			entries: []craftedEntry{{name: "lib/up", body: "../..", link: true}},
+			wantErr: `Archive entry "lib/up" is a symbolic link to "../.."`,
+		},
+		{
+			name:    "symbolic link to an absolute path",
+			entries: []craftedEntry{{name: "etc", body: "/etc", link: true}},
+			wantErr: "outside of the archive's directory",
+		},
+		{
+			name:    "symbolic link inside the archive",
+			entries: []craftedEntry{{name: "lib/current", body: "../app", link: true}},
+		},
+		{
+			name:    "too many entries",
+			entries: []craftedEntry{{name: "a"}, {name: "b"}, {name: "c"}},
+			limits:  ExtractLimits{MaxEntries: 2},
+			wantErr: "has 3 entries, more than the limit of 2",
+		},
+		{
+			name:    "too large",
+			entries: []craftedEntry{{name: "a", body: strings.Repeat("a", 600)}, {name: "b", body: strings.Repeat("b", 600)}},
+			limits:  ExtractLimits{MaxTotalSize: 1000},
+			wantErr: "extracts to 1200 bytes, more than the limit of 1000",
+		},
+		{
+			name:    "size recorded in the archive is a lie",
+			lying:   bytes.Repeat([]byte("\x00"), 4<<20),
+			limits:  ExtractLimits{MaxTotalSize: 1000},
+			wantErr: "extracts to more than the limit of 1000 bytes",
+		},
+		{
+			name:    "compression bomb recording a small size",
+			lying:   bytes.Repeat([]byte("\x00"), 4<<20),
+			limits:  ExtractLimits{MaxRatio: 100},
+			wantErr: `Archive entry "lie" is compressed more than the limit of 100 to 1`,
+		},
+		{
+			name:   "lying size within the limits",
+			lying:  []byte("0123456789abcdef"),
+			limits: DefaultExtractLimits,
+		},
+		{
+			name:    "compression bomb",
+			entries: []craftedEntry{{name: "zeros", body: strings.Repeat("\x00", 4<<20)}},
+			limits:  ExtractLimits{MaxRatio: 100},
+			wantErr: `Archive entry "zeros" is compressed more than the limit of 100 to 1`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "rewrite")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			archive := filepath.Join(dir, "app.jar")
+			if test.lying != nil {
+				writeLyingZip(t, archive, "lie", test.lying)
+			} else {
+				writeCraftedZip(t, archive, test.entries)
+			}
+
+			_, err = RewriteArchive(context.Background(), archive, filepath.Join(dir, "apigee_app.jar"), RewriteOptions{Limits: test.limits})
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("RewriteArchive() error = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("RewriteArchive() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestArchiveDirEntriesStopsWhenCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir_entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "config")
+	writeTestFiles(t, config, map[string]string{"myorg-test-config.yaml": "edge_config: {}\n", "extra/plugin.js": "x"})
+	archive := filepath.Join(dir, "app.jar")
+	writeTestZip(t, archive, map[string]string{"app/Main.class": "class"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := archiveDirEntries(ctx, []string{config}, SymlinkPreserve, nil); err != context.Canceled {
+		t.Errorf("archiveDirEntries() error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := VerifyArchive(ctx, filepath.Join(dir, "apigee_app.jar"), archive, RewriteOptions{Dirs: []string{config}}); err != context.Canceled {
+		t.Errorf("VerifyArchive() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+// benchmarkArchive writes a jar of a few thousand compressible classes and libraries that are already compressed,
+// along with a config directory to add to it
+func benchmarkArchive(b *testing.B) (string, string, func()) {
+	dir, err := ioutil.TempDir("", "rewrite_bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	random := rand.New(rand.NewSource(1))
+	words := []string{"public", "class", "static", "void", "return", "import", "java", "spring", "boot", "apigee"}
+	files := make(map[string]string)
+	for i := 0; i < 3000; i++ {
+		var class bytes.Buffer
+		for class.Len() < 8<<10 {
+			class.WriteString(words[random.Intn(len(words))])
+			class.WriteByte(' ')
+		}
+		files[fmt.Sprintf("BOOT-INF/classes/com/example/Class%d.class", i)] = class.String()
+	}
+	for i := 0; i < 40; i++ {
+		library := make([]byte, 256<<10)
+		random.Read(library)
+		files[fmt.Sprintf("BOOT-INF/lib/library-%d.jar", i)] = string(library)
+	}
+	archive := filepath.Join(dir, "app.jar")
+	writeTestZip(b, archive, files)
+	config := filepath.Join(dir, "config")
+	writeTestFiles(b, config, map[string]string{"myorg-test-config.yaml": "edge_config: {}\n"})
+	return archive, config, func() { os.RemoveAll(dir) }
+}
+
+func BenchmarkRewriteArchive(b *testing.B) {
+	archive, config, cleanup := benchmarkArchive(b)
+	defer cleanup()
+	dest := filepath.Join(filepath.Dir(archive), "apigee_app.jar")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := RewriteArchive(context.Background(), archive, dest, RewriteOptions{Dirs: []string{config}, Limits: DefaultExtractLimits})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// extractAndCompress is how apigee-push decorated archives before RewriteArchive, kept as the baseline it is measured
+// against: the archive is extracted to tempDir, config is copied in and everything is compressed again into dest
+func extractAndCompress(b *testing.B, archive, config, tempDir, dest string) {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		fpath := filepath.Join(tempDir, filepath.FromSlash(f.Name))
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(fpath, 0755); err != nil {
+				b.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			b.Fatal(err)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			b.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fpath, data, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := CopyDir(context.Background(), config, filepath.Join(tempDir, filepath.Base(config)), SymlinkPreserve); err != nil {
+		b.Fatal(err)
+	}
+
+	target, err := os.Create(dest)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer target.Close()
+	w := zip.NewWriter(target)
+	err = walkDir(context.Background(), tempDir, SymlinkPreserve, func(name, fpath string, info os.FileInfo) error {
+		if name == "." {
+			return nil
+		}
+		return addEntry(context.Background(), w, filepath.ToSlash(name), fpath, info, nil)
+	})
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkExtractAndCompress(b *testing.B) {
+	archive, config, cleanup := benchmarkArchive(b)
+	defer cleanup()
+	dest := filepath.Join(filepath.Dir(archive), "apigee_app.jar")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tempDir, err := ioutil.TempDir(filepath.Dir(archive), "tmp_archive")
+		if err != nil {
+			b.Fatal(err)
+		}
+		extractAndCompress(b, archive, config, tempDir, dest)
+		os.RemoveAll(tempDir)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+)
+
+//AppRoute is an HTTP route mapped to an application
+type AppRoute struct {
+	Host   string
+	Domain string
+	Path   string
+}
+
+//String formats the route the way cf shows it, as host.domain/path
+func (r AppRoute) String() string {
+	route := r.Domain
+	if r.Host != "" {
+		route = r.Host + "." + route
+	}
+	return route + r.Path
+}
+
+//RouteServiceArgs returns the arguments of a cf route service command, such as bind-route-service, for the route
+func (r AppRoute) RouteServiceArgs(command, service string) []string {
+	args := []string{command, r.Domain, service}
+	if r.Host != "" {
+		args = append(args, "--hostname", r.Host)
+	}
+	if r.Path != "" {
+		args = append(args, "--path", strings.TrimPrefix(r.Path, "/"))
+	}
+	return args
+}
+
+//AppRoutes returns the HTTP routes of an application, optionally limited to one domain. TCP routes are left out
+//since route services cannot be bound to them
+func AppRoutes(app plugin_models.GetAppModel, domain string) []AppRoute {
+	routes := make([]AppRoute, 0, len(app.Routes))
+	for _, route := range app.Routes {
+		if route.Port != 0 || (domain != "" && route.Domain.Name != domain) {
+			continue
+		}
+		routes = append(routes, AppRoute{Host: route.Host, Domain: route.Domain.Name, Path: route.Path})
+	}
+	return routes
+}
+
+//SelectRoute picks the route given with --route out of routes, accepting it with or without a scheme or trailing slash
+func SelectRoute(routes []AppRoute, route string) (AppRoute, bool) {
+	route = strings.TrimPrefix(strings.TrimPrefix(route, "https://"), "http://")
+	route = strings.TrimSuffix(route, "/")
+	for _, candidate := range routes {
+		if strings.EqualFold(candidate.String(), route) {
+			return candidate, true
+		}
+	}
+	return AppRoute{}, false
+}
+
+//ResolveRoute looks up the routes of the named application and returns the one to bind. A route given with --route
+//must belong to the application, otherwise an application with a single route uses it and one with several routes
+//asks which to use
+func (c *ApigeeBrokerPlugin) ResolveRoute(cliConnection plugin.CliConnection, appName, route, domain string) (AppRoute, error) {
+	app, err := cliConnection.GetApp(appName)
+	if err != nil {
+		return AppRoute{}, newCommandErrorf(ExitCfCommand, "Error looking up routes of application \"%s\": %s", appName, err.Error())
+	}
+	routes := AppRoutes(app, domain)
+	if len(routes) == 0 {
+		if domain != "" {
+			return AppRoute{}, newCommandErrorf(ExitUsage, "Error: Application \"%s\" has no HTTP routes on domain \"%s\"", appName, domain)
+		}
+		return AppRoute{}, newCommandErrorf(ExitUsage, "Error: Application \"%s\" has no HTTP routes", appName)
+	}
+
+	if route != "" {
+		selected, ok := SelectRoute(routes, route)
+		if !ok {
+			return AppRoute{}, newCommandErrorf(ExitUsage, "Error: Application \"%s\" has no route \"%s\", its routes are: %s", appName, route, joinRoutes(routes))
+		}
+		return selected, nil
+	}
+	if len(routes) == 1 {
+		fmt.Fprintf(c.Out, "Using route %s of application %s\n", routes[0], appName)
+		return routes[0], nil
+	}
+	if c.nonInteractive {
+		return AppRoute{}, missingInputError([]string{"--route"})
+	}
+
+	fmt.Fprintf(c.Out, "Application %s has several routes:\n", appName)
+	for i, candidate := range routes {
+		fmt.Fprintf(c.Out, "  %d. %s\n", i+1, candidate)
+	}
+	fmt.Fprintf(c.Out, "Route to use [1-%d]: ", len(routes))
+	tmp, _ := c.input().ReadString('\n')
+	answer := strings.TrimSpace(tmp)
+	if index, err := strconv.Atoi(answer); err == nil && index >= 1 && index <= len(routes) {
+		return routes[index-1], nil
+	}
+	if selected, ok := SelectRoute(routes, answer); ok {
+		return selected, nil
+	}
+	return AppRoute{}, newCommandErrorf(ExitUsage, "Error: \"%s\" is not one of the routes of application \"%s\"", answer, appName)
+}
+
+func joinRoutes(routes []AppRoute) string {
+	names := make([]string, len(routes))
+	for i, route := range routes {
+		names[i] = route.String()
+	}
+	return strings.Join(names, ", ")
+}
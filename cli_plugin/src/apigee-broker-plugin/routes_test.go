@@ -0,0 +1,74 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAppRoutes(t *testing.T) {
+	routes := AppRoutes(testApps["multi"], "")
+	want := []AppRoute{
+		{Host: "multi", Domain: "apps.example.com"},
+		{Host: "multi", Domain: "apps.internal"},
+		{Host: "api", Domain: "example.com", Path: "/v1"},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("AppRoutes() = %v, want %v", routes, want)
+	}
+	routes = AppRoutes(testApps["multi"], "example.com")
+	if !reflect.DeepEqual(routes, want[2:]) {
+		t.Errorf("AppRoutes(example.com) = %v, want %v", routes, want[2:])
+	}
+}
+
+func TestRouteServiceArgs(t *testing.T) {
+	tests := []struct {
+		route AppRoute
+		want  []string
+	}{
+		{AppRoute{Host: "myapp", Domain: "apps.example.com"}, []string{"bind-route-service", "apps.example.com", "svc", "--hostname", "myapp"}},
+		{AppRoute{Domain: "example.com", Path: "/v1/orders"}, []string{"bind-route-service", "example.com", "svc", "--path", "v1/orders"}},
+	}
+	for _, test := range tests {
+		args := test.route.RouteServiceArgs("bind-route-service", "svc")
+		if !reflect.DeepEqual(args, test.want) {
+			t.Errorf("RouteServiceArgs(%s) = %q, want %q", test.route, args, test.want)
+		}
+	}
+}
+
+func TestSelectRoute(t *testing.T) {
+	routes := AppRoutes(testApps["multi"], "")
+	tests := []struct {
+		route string
+		want  AppRoute
+		found bool
+	}{
+		{"multi.apps.internal", routes[1], true},
+		{"https://API.example.com/v1/", routes[2], true},
+		{"api.example.com", AppRoute{}, false},
+		{"multi.apps", AppRoute{}, false},
+	}
+	for _, test := range tests {
+		route, found := SelectRoute(routes, test.route)
+		if route != test.want || found != test.found {
+			t.Errorf("SelectRoute(%s) = %v, %v, want %v, %v", test.route, route, found, test.want, test.found)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/cli/plugin"
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+)
+
+//ServiceOffering is the name the Apigee service broker registers its service under
+const ServiceOffering = "apigee-edge"
+
+//Plan is a plan of the Apigee service offering
+type Plan string
+
+//Plans offered by the Apigee service broker
+const (
+	PlanOrg        Plan = "org"
+	PlanMicro      Plan = "microgateway"
+	PlanCoresident Plan = "microgateway-coresident"
+)
+
+var knownPlans = []Plan{PlanOrg, PlanMicro, PlanCoresident}
+
+//IsRoutePlan reports whether the plan is bound to application routes with bind-route-service, rather than to the
+//application itself with bind-service
+func (p Plan) IsRoutePlan() bool {
+	return p != PlanCoresident
+}
+
+//SuggestService fills in the service instance when it was not given and the space has exactly one Apigee service
+//instance of the given plans
+func (c *ApigeeBrokerPlugin) SuggestService(cliConnection plugin.CliConnection, service *string, plans ...Plan) error {
+	if *service != "" {
+		return nil
+	}
+	services, err := cliConnection.GetServices()
+	if err != nil {
+		return newCommandErrorf(ExitCfCommand, "Error listing service instances: %s", err.Error())
+	}
+	var candidates []plugin_models.GetServices_Model
+	for _, instance := range services {
+		if !instance.IsUserProvided && instance.Service.Name == ServiceOffering && hasPlan(plans, Plan(instance.ServicePlan.Name)) {
+			candidates = append(candidates, instance)
+		}
+	}
+	if len(candidates) == 1 {
+		*service = candidates[0].Name
+		fmt.Fprintf(c.Out, "Using service instance %s of the %s plan\n", *service, candidates[0].ServicePlan.Name)
+	}
+	return nil
+}
+
+func hasPlan(plans []Plan, plan Plan) bool {
+	for _, known := range plans {
+		if plan == known {
+			return true
+		}
+	}
+	return false
+}
+
+//ValidateService looks up the service instance and checks it with CheckService, returning the instance along with
+//its plan
+func (c *ApigeeBrokerPlugin) ValidateService(cliConnection plugin.CliConnection, service string, plan Plan, bind bool) (*plugin_models.GetService_Model, Plan, error) {
+	instance, err := cliConnection.GetService(service)
+	if err != nil {
+		return nil, "", newCommandErrorf(ExitCfCommand, "Error looking up service instance \"%s\": %s", service, err.Error())
+	}
+	actual, err := CheckService(instance, service, plan, bind)
+	if err != nil {
+		return nil, "", err
+	}
+	return &instance, actual, nil
+}
+
+//validateResolved checks the service instance a command was given, which ResolvePlan has already looked up as
+//resolved unless it is nil. Otherwise the instance is looked up with ValidateService
+func (c *ApigeeBrokerPlugin) validateResolved(cliConnection plugin.CliConnection, resolved *plugin_models.GetService_Model, service string, plan Plan, bind bool) error {
+	if resolved != nil && resolved.Name == service {
+		_, err := CheckService(*resolved, service, plan, bind)
+		return err
+	}
+	_, _, err := c.ValidateService(cliConnection, service, plan, bind)
+	return err
+}
+
+//CheckService makes sure the service instance belongs to the Apigee service offering and uses the plan the command is
+//meant for, any plan when plan is "", returning the instance's plan. On a mismatch the error points to apigee-bind or
+//apigee-unbind, which pick the plan themselves
+func CheckService(instance plugin_models.GetService_Model, service string, plan Plan, bind bool) (Plan, error) {
+	if instance.IsUserProvided {
+		return "", newCommandErrorf(ExitUsage, "Error: Service instance \"%s\" is a user-provided service, not an instance of the \"%s\" service", service, ServiceOffering)
+	}
+	if instance.ServiceOffering.Name != ServiceOffering {
+		return "", newCommandErrorf(ExitUsage, "Error: Service instance \"%s\" is an instance of the \"%s\" service, not the \"%s\" service", service, instance.ServiceOffering.Name, ServiceOffering)
+	}
+	actual := Plan(instance.ServicePlan.Name)
+	if !hasPlan(knownPlans, actual) {
+		return "", newCommandErrorf(ExitUsage, "Error: Service instance \"%s\" uses the \"%s\" plan, which this plugin does not support", service, actual)
+	}
+	if plan != "" && actual != plan {
+		command := "apigee-unbind"
+		if bind {
+			command = "apigee-bind"
+		}
+		return "", newCommandErrorf(ExitUsage, "Error: Service instance \"%s\" uses the \"%s\" plan, not the \"%s\" plan. Use \"cf %s\" instead", service, actual, plan, command)
+	}
+	return actual, nil
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+)
+
+func TestServiceValidation(t *testing.T) {
+	orgArgs := []string{"--app", "myapp", "--apigee_org", "myorg", "--apigee_env", "test", "--action", "proxy", "--bearer", "tok", "--non-interactive"}
+	twoOrgInstances := append([]plugin_models.GetServices_Model{testService("org-svc-2", ServiceOffering, PlanOrg)}, testServices...)
+	tests := []struct {
+		name     string
+		args     []string
+		services []plugin_models.GetServices_Model
+		want     [][]string
+		wantCode int
+		wantOut  string
+	}{
+		{
+			name: "single instance of the plan is used",
+			args: append([]string{"apigee-bind-org"}, orgArgs...),
+			want: [][]string{{"bind-route-service", "apps.example.com", "org-svc", "--hostname", "myapp", "-c",
+				`{"org":"myorg","env":"test","action":"proxy","protocol":"","bearer":"tok"}`}},
+			wantOut: "Using service instance org-svc of the org plan",
+		},
+		{
+			name:     "several instances of the plan need --service",
+			args:     append([]string{"apigee-bind-org"}, orgArgs...),
+			services: twoOrgInstances,
+			wantCode: ExitMissingInput,
+			wantOut:  "missing required values for: --service",
+		},
+		{
+			name:     "several instances of the plan are not guessed between",
+			args:     []string{"apigee-unbind-mgc", "--app", "myapp", "--non-interactive"},
+			wantCode: ExitMissingInput,
+			wantOut:  "missing required values for: --service",
+		},
+		{
+			name:     "instance of another plan",
+			args:     append([]string{"apigee-bind-org", "--service", "mgc-svc"}, orgArgs...),
+			wantCode: ExitUsage,
+			wantOut:  `Service instance "mgc-svc" uses the "microgateway-coresident" plan, not the "org" plan. Use "cf apigee-bind" instead`,
+		},
+		{
+			name:     "unbind from an instance of another plan",
+			args:     []string{"apigee-unbind-org", "--app", "myapp", "--service", "mg-svc"},
+			wantCode: ExitUsage,
+			wantOut:  `Use "cf apigee-unbind" instead`,
+		},
+		{
+			name:     "instance of another service",
+			args:     append([]string{"apigee-bind-org", "--service", "db"}, orgArgs...),
+			wantCode: ExitUsage,
+			wantOut:  `Service instance "db" is an instance of the "p-mysql" service, not the "apigee-edge" service`,
+		},
+		{
+			name:     "user-provided service",
+			args:     append([]string{"apigee-bind-mg", "--service", "ups", "--micro", "edgemicro.apps.example.com"}, orgArgs...),
+			wantCode: ExitUsage,
+			wantOut:  `Service instance "ups" is a user-provided service`,
+		},
+		{
+			name:     "missing instance",
+			args:     append([]string{"apigee-bind-org", "--service", "missing"}, orgArgs...),
+			wantCode: ExitCfCommand,
+			wantOut:  "Service instance missing not found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := &fakeCliConnection{services: test.services}
+			out, code := runPlugin(conn, test.args, "")
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d\noutput: %s", code, test.wantCode, out)
+			}
+			if !reflect.DeepEqual(conn.commands, test.want) {
+				t.Errorf("cf commands:\n got %q\nwant %q", conn.commands, test.want)
+			}
+			if !strings.Contains(out, test.wantOut) {
+				t.Errorf("output %q does not contain %q", out, test.wantOut)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//StageDir copies the application directory source into dest, leaving out what ignore lists, then merges dirs into
+//the copy under their own names, leaving out what DirIgnoreList lists for them with the exclude patterns. The files of
+//dirs are added even where ignore would leave them out. policy applies to the symbolic links in all of them. It
+//returns what was left out, and stops with ctx's error once ctx is cancelled
+func StageDir(ctx context.Context, source, dest string, ignore *IgnoreList, dirs []string, policy SymlinkPolicy, exclude []string) (SkipSummary, error) {
+	var skipped SkipSummary
+	// Directories are walked at their real paths
+	realDest, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error reading in directory \"%s\" info: %s", dest, err.Error())
+		return skipped, errors.New(errorMsg)
+	}
+	err = copyDir(ctx, source, dest, policy, func(name, fpath string, info os.FileInfo) bool {
+		// The staging directory may be inside the application directory when that is the temporary directory
+		if fpath == realDest {
+			return true
+		}
+		if ignore.Ignored(filepath.ToSlash(name), info.IsDir()) {
+			skipped.add(fpath, info)
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return skipped, err
+	}
+
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		dirIgnore, err := DirIgnoreList(dir, exclude)
+		if err != nil {
+			return skipped, err
+		}
+		err = copyDir(ctx, dir, filepath.Join(dest, filepath.Base(dir)), policy, func(name, fpath string, info os.FileInfo) bool {
+			if dirIgnore.Ignored(filepath.ToSlash(name), info.IsDir()) {
+				skipped.add(fpath, info)
+				return true
+			}
+			return false
+		})
+		if err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+//StageAdditions lists the files StageDir would merge into a copy of source for dirs, without copying anything, and
+//what it would leave out of them. Files that would replace one in the application directory are marked. It stops with
+//ctx's error once ctx is cancelled
+func StageAdditions(ctx context.Context, source string, dirs []string, policy SymlinkPolicy, exclude []string) ([]string, SkipSummary, error) {
+	entries, skipped, err := archiveDirEntries(ctx, dirs, policy, exclude)
+	if err != nil {
+		return nil, skipped, err
+	}
+	additions := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.zipName()
+		if info, err := os.Lstat(filepath.Join(source, filepath.FromSlash(entry.name))); err == nil && !info.IsDir() && !entry.info.IsDir() {
+			name += " (replaces the existing file)"
+		}
+		additions = append(additions, name)
+	}
+	return additions, skipped, nil
+}
+
+//stageApp copies the application directory to a temporary directory, leaving out what its .cfignore lists, and merges
+//dirs into the copy, leaving out what their ignore files and exclude list, returning the copy's path for the caller to
+//remove once it is pushed. In a dry run it only lists what would be added
+func (c *ApigeeBrokerPlugin) stageApp(appDir string, dirs []string, policy SymlinkPolicy, exclude []string) (string, error) {
+	ignore := NewIgnoreList()
+	err := ignore.AddFile(filepath.Join(appDir, CfIgnoreFile))
+	if err != nil {
+		return "", NewCommandError(ExitArchive, err)
+	}
+
+	if c.dryRun {
+		additions, skipped, err := StageAdditions(c.context(), appDir, dirs, policy, exclude)
+		if err != nil {
+			return "", NewCommandError(ExitArchive, err)
+		}
+		fmt.Fprintf(c.Out, "Would copy %s, leaving out what %s lists, with these files added:\n", appDir, CfIgnoreFile)
+		for _, entry := range additions {
+			fmt.Fprintf(c.Out, "  %s\n", entry)
+		}
+		if skipped.Files > 0 {
+			fmt.Fprintf(c.Out, "Would leave out %s of the microgateway directories\n", skipped)
+		}
+		return filepath.Join(os.TempDir(), "apigee-push-"+filepath.Base(appDir)), nil
+	}
+
+	staged, err := ioutil.TempDir("", "apigee-push-")
+	if err != nil {
+		return "", newCommandErrorf(ExitArchive, "Error making a directory to copy the application to: %s", err.Error())
+	}
+	c.trackTemp(staged)
+	skipped, err := StageDir(c.context(), appDir, staged, ignore, dirs, policy, exclude)
+	if err != nil {
+		return "", NewCommandError(ExitArchive, err)
+	}
+	fmt.Fprintf(c.Out, "Copied %s to %s with the microgateway directories added, leaving out %s matching ignore patterns\n", appDir, staged, skipped)
+	return staged, nil
+}
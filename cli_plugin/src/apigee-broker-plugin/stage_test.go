@@ -0,0 +1,172 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// listFiles returns the files under dir, relative to it with slashes, and their contents
+func listFiles(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(dir, fpath)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(fpath)
+		files[filepath.ToSlash(name)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestPushStagesAppDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push_stage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Staging directories are made in TMPDIR, so that they can be checked for
+	tmp := filepath.Join(dir, "tmp")
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmp)
+
+	appDir := filepath.Join(dir, "app")
+	appFiles := map[string]string{
+		"index.js":                      "require('./lib')\n",
+		"lib/index.js":                  "module.exports = {}\n",
+		"node_modules/dep/dep.js":       "dep\n",
+		"logs/app.log":                  "log\n",
+		"manifest.yml":                  "applications: []\n",
+		".cfignore":                     "node_modules/\n*.log\nconfig\n",
+		"config/old-config.yaml":        "old\n",
+		"config/myorg-test-config.yaml": "stale\n",
+	}
+	writeTestFiles(t, appDir, appFiles)
+	config := filepath.Join(dir, "config")
+	writeTestFiles(t, config, map[string]string{"myorg-test-config.yaml": "edge_config: {}\n"})
+	plugins := filepath.Join(dir, "plugins")
+	writeTestFiles(t, plugins, map[string]string{"custom/index.js": "module.exports = {}\n"})
+
+	var staged map[string]string
+	var stagedDir string
+	conn := &fakeCliConnection{onCommand: func(args []string) {
+		stagedDir = args[len(args)-2]
+		staged = listFiles(t, stagedDir)
+	}}
+	out, code := runPlugin(conn, []string{"apigee-push", "--app", "myapp", "--path", appDir, "--config", config, "--plugins", plugins, "--coresident", "--non-interactive"}, "")
+	if code != 0 {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+	want := [][]string{{"push", "myapp", "-p", stagedDir, "--no-start"}}
+	if !reflect.DeepEqual(conn.commands, want) || !strings.HasPrefix(stagedDir, tmp) {
+		t.Errorf("cf commands:\n got %q\nwant a push of a directory in %s", conn.commands, tmp)
+	}
+	wantStaged := map[string]string{
+		"index.js":                      "require('./lib')\n",
+		"lib/index.js":                  "module.exports = {}\n",
+		"config/myorg-test-config.yaml": "edge_config: {}\n",
+		"plugins/custom/index.js":       "module.exports = {}\n",
+	}
+	if !reflect.DeepEqual(staged, wantStaged) {
+		t.Errorf("staged files:\n got %v\nwant %v", staged, wantStaged)
+	}
+	if !strings.Contains(out, "leaving out 6 files (62 bytes) matching ignore patterns") {
+		t.Errorf("output %q does not count the ignored files", out)
+	}
+	if got := listFiles(t, appDir); !reflect.DeepEqual(got, appFiles) {
+		t.Errorf("application directory changed:\n got %v\nwant %v", got, appFiles)
+	}
+	if left, _ := filepath.Glob(filepath.Join(tmp, "*")); len(left) != 0 {
+		t.Errorf("staging directory left behind: %v", left)
+	}
+}
+
+func TestPushStagesAppDirectoryDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push_stage_dry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	appDir := filepath.Join(dir, "app")
+	writeTestFiles(t, appDir, map[string]string{"index.js": "", "config/myorg-test-config.yaml": "stale\n"})
+	config := filepath.Join(dir, "config")
+	writeTestFiles(t, config, map[string]string{"myorg-test-config.yaml": "edge_config: {}\n"})
+
+	conn := &fakeCliConnection{}
+	// An application directory given with --archive is staged too
+	out, code := runPlugin(conn, []string{"apigee-push", "--dry-run", "--app", "myapp", "--archive", appDir, "--config", config, "--coresident", "--non-interactive"}, "")
+	if code != 0 {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+	if len(conn.commands) != 0 {
+		t.Errorf("dry run ran cf commands %q", conn.commands)
+	}
+	for _, want := range []string{"Would copy " + appDir, "  config/\n", "  config/myorg-test-config.yaml (replaces the existing file)\n", "Would run: cf push myapp -p "} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q does not contain %q", out, want)
+		}
+	}
+}
+
+func TestPushArchiveAndPathConflict(t *testing.T) {
+	out, code := runPlugin(&fakeCliConnection{}, []string{"apigee-push", "--app", "myapp", "--archive", "app.jar", "--path", "app", "--config", "config", "--coresident", "--non-interactive"}, "")
+	if code != ExitUsage || !strings.Contains(out, "--archive and --path cannot be used together") {
+		t.Errorf("exit code %d, want %d\noutput: %s", code, ExitUsage, out)
+	}
+}
+
+func TestStageDirSkipsItself(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stage_self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFiles(t, dir, map[string]string{"index.js": "x"})
+	dest := filepath.Join(dir, "staged")
+	if err := os.Mkdir(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StageDir(context.Background(), dir, dest, NewIgnoreList(), nil, SymlinkPreserve, nil); err != nil {
+		t.Fatalf("StageDir() error = %v", err)
+	}
+	got := make([]string, 0)
+	for name := range listFiles(t, dest) {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, []string{"index.js"}) {
+		t.Errorf("staged %v, want only index.js", got)
+	}
+}
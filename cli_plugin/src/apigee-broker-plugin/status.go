@@ -0,0 +1,329 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+//StatusRow is one binding of an Apigee service instance shown by apigee-status
+type StatusRow struct {
+	App             string
+	Route           string
+	Plan            Plan
+	Instance        string
+	RouteServiceURL string
+	Credentials     map[string]interface{}
+}
+
+//apiPage is one page of a Cloud Controller list response, covering both the v2 and v3 formats along with their
+//error responses
+type apiPage struct {
+	NextURL    string `json:"next_url"`
+	Pagination struct {
+		Next *struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"pagination"`
+	Resources []json.RawMessage `json:"resources"`
+	Included  struct {
+		Routes []json.RawMessage `json:"routes"`
+	} `json:"included"`
+
+	Description string `json:"description"`
+	Errors      []struct {
+		Detail string `json:"detail"`
+	} `json:"errors"`
+}
+
+type serviceBindingResource struct {
+	Entity struct {
+		AppGUID     string                 `json:"app_guid"`
+		Credentials map[string]interface{} `json:"credentials"`
+	} `json:"entity"`
+}
+
+type routeBindingResource struct {
+	RouteServiceURL string `json:"route_service_url"`
+	Relationships   struct {
+		Route struct {
+			Data struct {
+				GUID string `json:"guid"`
+			} `json:"data"`
+		} `json:"route"`
+	} `json:"relationships"`
+}
+
+type routeResource struct {
+	GUID         string `json:"guid"`
+	URL          string `json:"url"`
+	Destinations []struct {
+		App struct {
+			GUID string `json:"guid"`
+		} `json:"app"`
+	} `json:"destinations"`
+}
+
+//CurlPages runs "cf curl" on path and every following page of the response
+func CurlPages(cliConnection plugin.CliConnection, path string) ([]apiPage, error) {
+	pages := make([]apiPage, 0)
+	for path != "" {
+		output, err := cliConnection.CliCommandWithoutTerminalOutput("curl", path)
+		if err != nil {
+			return nil, newCommandErrorf(ExitCfCommand, "Error requesting \"%s\": %s", path, err.Error())
+		}
+		var page apiPage
+		err = json.Unmarshal([]byte(strings.Join(output, "\n")), &page)
+		if err != nil {
+			return nil, newCommandErrorf(ExitCfCommand, "Error parsing the response to \"%s\": %s", path, err.Error())
+		}
+		if page.Description != "" {
+			return nil, newCommandErrorf(ExitCfCommand, "Error requesting \"%s\": %s", path, page.Description)
+		}
+		if len(page.Errors) > 0 {
+			return nil, newCommandErrorf(ExitCfCommand, "Error requesting \"%s\": %s", path, page.Errors[0].Detail)
+		}
+		pages = append(pages, page)
+
+		path = page.NextURL
+		if page.Pagination.Next != nil {
+			// v3 links are absolute but cf curl wants a path
+			next, err := url.Parse(page.Pagination.Next.Href)
+			if err != nil {
+				return nil, newCommandErrorf(ExitCfCommand, "Error parsing next page link \"%s\": %s", page.Pagination.Next.Href, err.Error())
+			}
+			path = next.RequestURI()
+		}
+	}
+	return pages, nil
+}
+
+//StatusRows collects the app and route bindings of every Apigee service instance in the targeted space. App bindings
+//and their credentials come from the v2 API, route bindings and their route service URLs from the v3 API, as each
+//version only exposes one of them
+func StatusRows(cliConnection plugin.CliConnection) ([]StatusRow, error) {
+	services, err := cliConnection.GetServices()
+	if err != nil {
+		return nil, newCommandErrorf(ExitCfCommand, "Error listing service instances: %s", err.Error())
+	}
+	apps, err := cliConnection.GetApps()
+	if err != nil {
+		return nil, newCommandErrorf(ExitCfCommand, "Error listing applications: %s", err.Error())
+	}
+	appNames := make(map[string]string)
+	appRoutes := make(map[string]string)
+	for _, app := range apps {
+		appNames[app.Guid] = app.Name
+		routes := make([]string, 0, len(app.Routes))
+		for _, route := range app.Routes {
+			routes = append(routes, AppRoute{Host: route.Host, Domain: route.Domain.Name}.String())
+		}
+		appRoutes[app.Guid] = strings.Join(routes, ", ")
+	}
+
+	rows := make([]StatusRow, 0)
+	for _, instance := range services {
+		if instance.IsUserProvided || instance.Service.Name != ServiceOffering {
+			continue
+		}
+		plan := Plan(instance.ServicePlan.Name)
+
+		if !plan.IsRoutePlan() {
+			pages, err := CurlPages(cliConnection, "/v2/service_instances/"+instance.Guid+"/service_bindings")
+			if err != nil {
+				return nil, err
+			}
+			for _, page := range pages {
+				for _, raw := range page.Resources {
+					var binding serviceBindingResource
+					err = json.Unmarshal(raw, &binding)
+					if err != nil {
+						return nil, newCommandErrorf(ExitCfCommand, "Error parsing service binding: %s", err.Error())
+					}
+					rows = append(rows, StatusRow{
+						App:         nameOrGUID(appNames, binding.Entity.AppGUID),
+						Route:       appRoutes[binding.Entity.AppGUID],
+						Plan:        plan,
+						Instance:    instance.Name,
+						Credentials: binding.Entity.Credentials,
+					})
+				}
+			}
+			continue
+		}
+
+		pages, err := CurlPages(cliConnection, "/v3/service_route_bindings?include=route&service_instance_guids="+instance.Guid)
+		if err != nil {
+			return nil, err
+		}
+		routes := make(map[string]routeResource)
+		for _, page := range pages {
+			for _, raw := range page.Included.Routes {
+				var route routeResource
+				err = json.Unmarshal(raw, &route)
+				if err != nil {
+					return nil, newCommandErrorf(ExitCfCommand, "Error parsing route: %s", err.Error())
+				}
+				routes[route.GUID] = route
+			}
+		}
+		for _, page := range pages {
+			for _, raw := range page.Resources {
+				var binding routeBindingResource
+				err = json.Unmarshal(raw, &binding)
+				if err != nil {
+					return nil, newCommandErrorf(ExitCfCommand, "Error parsing route binding: %s", err.Error())
+				}
+				route := routes[binding.Relationships.Route.Data.GUID]
+				names := make([]string, 0, len(route.Destinations))
+				for _, destination := range route.Destinations {
+					names = append(names, nameOrGUID(appNames, destination.App.GUID))
+				}
+				rows = append(rows, StatusRow{
+					App:             strings.Join(names, ", "),
+					Route:           route.URL,
+					Plan:            plan,
+					Instance:        instance.Name,
+					RouteServiceURL: binding.RouteServiceURL,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Instance != rows[j].Instance {
+			return rows[i].Instance < rows[j].Instance
+		}
+		if rows[i].App != rows[j].App {
+			return rows[i].App < rows[j].App
+		}
+		return rows[i].Route < rows[j].Route
+	})
+	return rows, nil
+}
+
+func nameOrGUID(names map[string]string, guid string) string {
+	if name, ok := names[guid]; ok {
+		return name
+	}
+	return guid
+}
+
+//MaskCredentials formats binding credentials as sorted key=value pairs, hiding the values of secrets. Nested objects
+//and lists are shown as JSON, with the secrets inside them hidden too
+func MaskCredentials(credentials map[string]interface{}) string {
+	keys := make([]string, 0, len(credentials))
+	for key := range credentials {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		var value string
+		switch nested := credentials[key].(type) {
+		case map[string]interface{}, []interface{}:
+			data, err := json.Marshal(maskSecrets(nested))
+			if err != nil {
+				value = Redacted
+			} else {
+				value = string(data)
+			}
+		default:
+			value = fmt.Sprint(nested)
+		}
+		if isSecretKey(key) {
+			value = Redacted
+		}
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, " ")
+}
+
+//maskSecrets returns a copy of a decoded JSON value with the values of secret keys hidden at any depth
+func maskSecrets(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(value))
+		for key, nested := range value {
+			if isSecretKey(key) {
+				masked[key] = Redacted
+			} else {
+				masked[key] = maskSecrets(nested)
+			}
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(value))
+		for i, nested := range value {
+			masked[i] = maskSecrets(nested)
+		}
+		return masked
+	}
+	return value
+}
+
+//ApigeeStatusCommand shows every app and route bound to an Apigee service instance in the targeted space
+func (c *ApigeeBrokerPlugin) ApigeeStatusCommand(cliConnection plugin.CliConnection, args []string) error {
+	flags := flag.NewFlagSet("apigee-status", flag.ContinueOnError)
+	flags.SetOutput(c.Out)
+
+	// Parse from [1] since [0] is command name
+	err := flags.Parse(args[1:])
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return newCommandErrorf(ExitUsage, "Error: Couldn't parse arguments: %s", err.Error())
+	}
+
+	// Check to make sure there are no extra arguments
+	if flags.NArg() > 0 {
+		return newCommandErrorf(ExitUsage, "Error: Unknown extra arguments")
+	}
+
+	rows, err := StatusRows(cliConnection)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		fmt.Fprintln(c.Out, "No applications are bound to Apigee service instances in this space")
+		return nil
+	}
+
+	table := tabwriter.NewWriter(c.Out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(table, "app\troute\tplan\tinstance\troute_service_url\tcredentials")
+	for _, row := range rows {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", dashIfEmpty(row.App), dashIfEmpty(row.Route), row.Plan, row.Instance,
+			dashIfEmpty(row.RouteServiceURL), dashIfEmpty(MaskCredentials(row.Credentials)))
+	}
+	return table.Flush()
+}
+
+func dashIfEmpty(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApigeeStatus(t *testing.T) {
+	conn := &fakeCliConnection{curl: map[string]string{
+		"/v2/service_instances/mgc-svc-guid/service_bindings": `{"next_url":"/v2/service_instances/mgc-svc-guid/service_bindings?page=2","resources":[
+			{"entity":{"app_guid":"myapp-guid","credentials":{"edgemicro_key":"key1","edgemicro_secret":"s3cr3t-value","apigee_org":"myorg"}}}]}`,
+		"/v2/service_instances/mgc-svc-guid/service_bindings?page=2": `{"next_url":null,"resources":[
+			{"entity":{"app_guid":"deleted-guid","credentials":{}}}]}`,
+		"/v2/service_instances/other-svc-guid/service_bindings": `{"next_url":null,"resources":[]}`,
+		"/v3/service_route_bindings?include=route&service_instance_guids=org-svc-guid": `{
+			"pagination":{"next":{"href":"https://api.example.com/v3/service_route_bindings?include=route&page=2&service_instance_guids=org-svc-guid"}},
+			"resources":[{"route_service_url":"https://myorg-test.apigee.net/myapp","relationships":{"route":{"data":{"guid":"r1"}}}}],
+			"included":{"routes":[{"guid":"r1","url":"myapp.apps.example.com","destinations":[{"app":{"guid":"myapp-guid"}}]}]}}`,
+		"/v3/service_route_bindings?include=route&page=2&service_instance_guids=org-svc-guid": `{
+			"pagination":{"next":null},
+			"resources":[{"route_service_url":"https://myorg-test.apigee.net/v1","relationships":{"route":{"data":{"guid":"r2"}}}}],
+			"included":{"routes":[{"guid":"r2","url":"api.example.com/v1","destinations":[{"app":{"guid":"multi-guid"}}]}]}}`,
+		"/v3/service_route_bindings?include=route&service_instance_guids=mg-svc-guid": `{"pagination":{"next":null},"resources":[]}`,
+	}}
+	out, code := runPlugin(conn, []string{"apigee-status"}, "")
+	if code != ExitOK {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+
+	want := []string{
+		"app            route                    plan                      instance   route_service_url                     credentials",
+		"deleted-guid   -                        microgateway-coresident   mgc-svc    -                                     -",
+		"myapp          myapp.apps.example.com   microgateway-coresident   mgc-svc    -                                     apigee_org=myorg edgemicro_key=key1 edgemicro_secret=********",
+		"multi          api.example.com/v1       org                       org-svc    https://myorg-test.apigee.net/v1      -",
+		"myapp          myapp.apps.example.com   org                       org-svc    https://myorg-test.apigee.net/myapp   -",
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " ")
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("output:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+	if strings.Contains(out, "s3cr3t-value") {
+		t.Errorf("output reveals the edgemicro secret:\n%s", out)
+	}
+}
+
+func TestApigeeStatusErrors(t *testing.T) {
+	conn := &fakeCliConnection{curl: map[string]string{
+		"/v2/service_instances/mgc-svc-guid/service_bindings": `{"code":10000,"description":"Unknown request","error_code":"CF-NotFound"}`,
+	}}
+	out, code := runPlugin(conn, []string{"apigee-status"}, "")
+	if code != ExitCfCommand || !strings.Contains(out, "Unknown request") {
+		t.Errorf("exit code %d, want %d\noutput: %s", code, ExitCfCommand, out)
+	}
+}
+
+func TestMaskCredentials(t *testing.T) {
+	masked := MaskCredentials(map[string]interface{}{"user": "me", "Password": "p", "api_token": "t", "port": 8080})
+	if masked != "Password=******** api_token=******** port=8080 user=me" {
+		t.Errorf("MaskCredentials() = %q", masked)
+	}
+
+	masked = MaskCredentials(map[string]interface{}{
+		"edge":  map[string]interface{}{"user": "me", "pass": "x", "keys": []interface{}{map[string]interface{}{"secret": "s", "id": "k"}}},
+		"hosts": []interface{}{"a", "b"},
+	})
+	if masked != `edge={"keys":[{"id":"k","secret":"********"}],"pass":"********","user":"me"} hosts=["a","b"]` {
+		t.Errorf("MaskCredentials() = %q", masked)
+	}
+}
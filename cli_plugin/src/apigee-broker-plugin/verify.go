@@ -0,0 +1,162 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// microgateway reads its configuration from a file named <org>-<env>-config.yaml
+const configSuffix = "-config.yaml"
+
+//expectedEntry is what an entry of a decorated archive should hold
+type expectedEntry struct {
+	crc  uint32
+	size uint64
+}
+
+//addedChecksum returns the CRC-32 and size of what addEntry stores for an added file, directory or symbolic link
+func addedChecksum(entry dirEntry) (expectedEntry, error) {
+	if entry.info.IsDir() {
+		return expectedEntry{}, nil
+	}
+	if entry.info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(entry.fpath)
+		if err != nil {
+			errorMsg := fmt.Sprintf("Error reading symbolic link \"%s\": %s", entry.fpath, err.Error())
+			return expectedEntry{}, errors.New(errorMsg)
+		}
+		target := []byte(filepath.ToSlash(link))
+		return expectedEntry{crc: crc32.ChecksumIEEE(target), size: uint64(len(target))}, nil
+	}
+	f, err := os.Open(entry.fpath)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error reading in file \"%s\": %s", entry.fpath, err.Error())
+		return expectedEntry{}, errors.New(errorMsg)
+	}
+	defer f.Close()
+	hash := crc32.NewIEEE()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Error reading in file \"%s\": %s", entry.fpath, err.Error())
+		return expectedEntry{}, errors.New(errorMsg)
+	}
+	return expectedEntry{crc: hash.Sum32(), size: uint64(n)}, nil
+}
+
+func verifyError(decorated, format string, args ...interface{}) error {
+	errorMsg := fmt.Sprintf("Error: Archive \"%s\" failed verification: ", decorated) + fmt.Sprintf(format, args...)
+	return errors.New(errorMsg)
+}
+
+//VerifyArchive checks that decorated, written by RewriteArchive from archive with options, opens cleanly and that
+//every entry decompresses to the CRC-32 and size it records. It must hold exactly the entries of archive, apart from
+//those the directories replace, with their CRC-32s, and the files of the directories as they are now, including a
+//microgateway config YAML. It returns the number of entries checked, and stops with ctx's error once ctx is cancelled
+func VerifyArchive(ctx context.Context, decorated, archive string, options RewriteOptions) (int, error) {
+	original, err := zip.OpenReader(archive)
+	if err != nil {
+		return 0, err
+	}
+	defer original.Close()
+	additions, _, err := archiveDirEntries(ctx, options.Dirs, options.Symlinks, options.Exclude)
+	if err != nil {
+		return 0, err
+	}
+
+	// Every copy of a name the archive repeats is kept, in order, unless the directories replace the name
+	want := make(map[string][]expectedEntry)
+	for _, entry := range additions {
+		expected, err := addedChecksum(entry)
+		if err != nil {
+			return 0, err
+		}
+		want[options.Prefix+entry.zipName()] = []expectedEntry{expected}
+	}
+	count := len(additions)
+	replaced := make(map[string]bool)
+	for name := range want {
+		replaced[name] = true
+	}
+	for _, f := range original.File {
+		if !replaced[f.Name] {
+			want[f.Name] = append(want[f.Name], expectedEntry{crc: f.CRC32, size: f.UncompressedSize64})
+			count++
+		}
+	}
+
+	r, err := zip.OpenReader(decorated)
+	if err != nil {
+		return 0, verifyError(decorated, "it cannot be opened: %s", err.Error())
+	}
+	defer r.Close()
+	if len(r.File) != count {
+		return 0, verifyError(decorated, "it has %d entries, %d were expected", len(r.File), count)
+	}
+
+	hasConfig := false
+	configDir := ""
+	if len(options.Dirs) > 0 && options.Dirs[0] != "" {
+		configDir = options.Prefix + filepath.Base(options.Dirs[0]) + "/"
+	}
+	for _, f := range r.File {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		copies, ok := want[f.Name]
+		if !ok {
+			return 0, verifyError(decorated, "entry \"%s\" was not expected", f.Name)
+		}
+		if len(copies) == 0 {
+			return 0, verifyError(decorated, "entry \"%s\" is there more times than expected", f.Name)
+		}
+		expected := copies[0]
+		want[f.Name] = copies[1:]
+		if f.CRC32 != expected.crc || f.UncompressedSize64 != expected.size {
+			return 0, verifyError(decorated, "entry \"%s\" holds %d bytes with CRC-32 %08x, %d bytes with CRC-32 %08x were expected",
+				f.Name, f.UncompressedSize64, f.CRC32, expected.size, expected.crc)
+		}
+		// Reading an entry to its end checks its contents against the CRC-32 and size recorded
+		rc, err := f.Open()
+		if err == nil {
+			_, err = copyContext(ctx, ioutil.Discard, rc)
+			rc.Close()
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			return 0, verifyError(decorated, "entry \"%s\" is damaged: %s", f.Name, err.Error())
+		}
+		if configDir != "" && strings.HasPrefix(f.Name, configDir) && strings.HasSuffix(f.Name, configSuffix) {
+			hasConfig = true
+		}
+	}
+	if configDir != "" && !hasConfig {
+		return 0, verifyError(decorated, "it holds no microgateway configuration file ending in \"%s\" in %s", configSuffix, configDir)
+	}
+	return len(r.File), nil
+}
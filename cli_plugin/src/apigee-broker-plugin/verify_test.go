@@ -0,0 +1,266 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//dropZipEntry rewrites archive without the entry called name, copying the others as they are
+func dropZipEntry(t testing.TB, archive, name string) {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range r.File {
+		if f.Name == name {
+			continue
+		}
+		raw, err := f.OpenRaw()
+		if err != nil {
+			t.Fatal(err)
+		}
+		target, err := w.CreateRaw(&f.FileHeader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(target, raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//editFile replaces the first occurrence of old in fpath with new, which must be as long
+func editFile(t testing.TB, fpath, old, new string) {
+	data, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(old)) {
+		t.Fatalf("%s does not contain %q", fpath, old)
+	}
+	if err := ioutil.WriteFile(fpath, bytes.Replace(data, []byte(old), []byte(new), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//verifyInputs writes an archive, config and plugins directories to dir, returning their paths
+func verifyInputs(t testing.TB, dir string) (string, string, string) {
+	archive := filepath.Join(dir, "app.jar")
+	writeTestZip(t, archive, map[string]string{"app/Main.class": "class", "config/old.yaml": "replaced"})
+	config := filepath.Join(dir, "config")
+	writeTestFiles(t, config, map[string]string{"myorg-test-config.yaml": "edge_config: {}\n", "old.yaml": "new"})
+	plugins := filepath.Join(dir, "plugins")
+	// Stored as they are, so their bytes can be found in the archive
+	writeTestFiles(t, plugins, map[string]string{"custom/logo.png": "PNG-CONTENTS"})
+	return archive, config, plugins
+}
+
+func TestVerifyArchive(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage changes the decorated archive, or what it was made from, after it is written
+		damage func(t *testing.T, decorated, config string)
+		// original, when set, replaces the entries of the archive decorated
+		original []craftedEntry
+		noYaml   bool
+		wantErr  string
+	}{
+		{name: "intact"},
+		{
+			name: "names repeated in the original",
+			original: []craftedEntry{{name: "app/Main.class", body: "one"}, {name: "app/Main.class", body: "two"},
+				{name: "config/old.yaml", body: "a"}, {name: "config/old.yaml", body: "b"}},
+		},
+		{
+			name:     "repeated name left out",
+			original: []craftedEntry{{name: "app/Main.class", body: "one"}, {name: "app/Main.class", body: "two"}},
+			damage: func(t *testing.T, decorated, config string) {
+				dropZipEntry(t, decorated, "app/Main.class")
+			},
+			wantErr: "it has 6 entries, 8 were expected",
+		},
+		{
+			name: "truncated",
+			damage: func(t *testing.T, decorated, config string) {
+				info, err := os.Stat(decorated)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Truncate(decorated, info.Size()/2); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "cannot be opened",
+		},
+		{
+			name: "corrupted contents",
+			damage: func(t *testing.T, decorated, config string) {
+				editFile(t, decorated, "PNG-CONTENTS", "PNG-CONTENTZ")
+			},
+			wantErr: "entry \"plugins/custom/logo.png\" is damaged",
+		},
+		{
+			name: "original entry missing",
+			damage: func(t *testing.T, decorated, config string) {
+				dropZipEntry(t, decorated, "app/Main.class")
+			},
+			wantErr: "it has 7 entries, 8 were expected",
+		},
+		{
+			name: "added file missing",
+			damage: func(t *testing.T, decorated, config string) {
+				dropZipEntry(t, decorated, "config/old.yaml")
+			},
+			wantErr: "it has 7 entries, 8 were expected",
+		},
+		{
+			name: "config changed since",
+			damage: func(t *testing.T, decorated, config string) {
+				editFile(t, filepath.Join(config, "myorg-test-config.yaml"), "{}", "[]")
+			},
+			wantErr: "entry \"config/myorg-test-config.yaml\" holds 16 bytes with CRC-32",
+		},
+		{
+			name:    "no config yaml",
+			noYaml:  true,
+			wantErr: "it holds no microgateway configuration file ending in \"-config.yaml\" in config/",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "verify_archive")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			archive, config, plugins := verifyInputs(t, dir)
+			if test.original != nil {
+				writeCraftedZip(t, archive, test.original)
+			}
+			if test.noYaml {
+				if err := os.Remove(filepath.Join(config, "myorg-test-config.yaml")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			decorated := filepath.Join(dir, "apigee_app.jar")
+			options := RewriteOptions{Dirs: []string{config, plugins}, Limits: DefaultExtractLimits}
+			if _, err := RewriteArchive(context.Background(), archive, decorated, options); err != nil {
+				t.Fatal(err)
+			}
+			if test.damage != nil {
+				test.damage(t, decorated, config)
+			}
+
+			_, err = VerifyArchive(context.Background(), decorated, archive, options)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("error %v does not contain %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPushVerifyOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push_verify_only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive, config, plugins := verifyInputs(t, dir)
+	args := []string{"apigee-push", "--archive", archive, "--config", config, "--plugins", plugins, "--coresident", "--non-interactive"}
+	out, code := runPlugin(&fakeCliConnection{}, args, "")
+	if code != 0 || !strings.Contains(out, "Verified "+filepath.Join(dir, "apigee_app.jar")+": 8 entries match") {
+		t.Fatalf("exit code %d\noutput: %s", code, out)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		change   func(t *testing.T)
+		wantCode int
+		wantOut  string
+	}{
+		{
+			name:    "matching",
+			args:    []string{"apigee-push", "--verify-only", "--archive", archive, "--config", config, "--plugins", plugins},
+			wantOut: "8 entries match",
+		},
+		{
+			name:     "plugins left out",
+			args:     []string{"apigee-push", "--verify-only", "--archive", archive, "--config", config},
+			wantCode: ExitArchive,
+			wantOut:  "it has 8 entries, 5 were expected",
+		},
+		{
+			name:     "config changed since",
+			args:     []string{"apigee-push", "--verify-only", "--archive", archive, "--config", config, "--plugins", plugins},
+			change:   func(t *testing.T) { editFile(t, filepath.Join(config, "old.yaml"), "new", "now") },
+			wantCode: ExitArchive,
+			wantOut:  "entry \"config/old.yaml\" holds 3 bytes",
+		},
+		{
+			name:     "config missing",
+			args:     []string{"apigee-push", "--verify-only", "--archive", archive},
+			wantCode: ExitUsage,
+			wantOut:  "--verify-only needs the original --archive and the --config directory",
+		},
+		{
+			name:     "with path",
+			args:     []string{"apigee-push", "--verify-only", "--path", dir, "--config", config},
+			wantCode: ExitUsage,
+			wantOut:  "cannot be used with --path",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.change != nil {
+				test.change(t)
+			}
+			conn := &fakeCliConnection{}
+			out, code := runPlugin(conn, test.args, "")
+			if code != test.wantCode || !strings.Contains(out, test.wantOut) {
+				t.Errorf("exit code %d, want %d\noutput %q does not contain %q", code, test.wantCode, out, test.wantOut)
+			}
+			if len(conn.commands) > 0 {
+				t.Errorf("cf commands run: %q", conn.commands)
+			}
+		})
+	}
+}